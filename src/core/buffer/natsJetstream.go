@@ -9,8 +9,12 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"log/slog"
+	"time"
 )
 
+// defaultDuplicateWindow covers the overlap of sources that fetch the last day of mail on every run
+const defaultDuplicateWindow = 48 * time.Hour
+
 type natsStreamingBuffer struct {
 	client   *nats.Conn
 	producer jetstream.JetStream
@@ -45,9 +49,23 @@ func NewNATSStreamingBuffer(ctx context.Context, config config.NatsConfig) (Buff
 			slog.String("error", err.Error()))
 	}
 
-	stream, err := producer.CreateStream(ctx, jetstream.StreamConfig{
-		Name:     config.Name,
-		Subjects: []string{fmt.Sprintf("%s.new", config.Name)},
+	duplicateWindow := defaultDuplicateWindow
+	if config.DuplicateWindow != "" {
+		duplicateWindow, err = time.ParseDuration(config.DuplicateWindow)
+		if err != nil || duplicateWindow <= 0 {
+			logger.Error("invalid duplicate window",
+				slog.String("component", "buffer"),
+				slog.String("duplicateWindow", config.DuplicateWindow))
+			return nil, fmt.Errorf("invalid duplicate window %s", config.DuplicateWindow)
+		}
+	}
+
+	// the stream drops the messages whose id it has seen within the window, which is how a document the sink returns
+	// on every ingestion of it is only processed once. Updating the stream applies a changed window to it
+	stream, err := producer.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       config.Name,
+		Subjects:   []string{fmt.Sprintf("%s.new", config.Name)},
+		Duplicates: duplicateWindow,
 	})
 	if err != nil {
 		logger.Error("could not create JetStream stream",
@@ -90,7 +108,7 @@ func (buffer *natsStreamingBuffer) EnqueueBatch(ctx context.Context, metadata []
 			slog.String("metadata", m.String()),
 			slog.String("component", "buffer"),
 			slog.String("name", buffer.name))
-		_, err := buffer.producer.Publish(ctx, fmt.Sprintf("%s.new", buffer.name), []byte(m.String()))
+		_, err := buffer.publish(ctx, m)
 		if err != nil {
 			logger.Error("could not enqueue metadata",
 				slog.String("name", buffer.name),
@@ -111,7 +129,7 @@ func (buffer *natsStreamingBuffer) Enqueue(ctx context.Context, metadata data.Me
 		slog.String("component", "buffer"),
 		slog.String("metadata", metadata.String()),
 		slog.String("name", buffer.name))
-	_, err := buffer.producer.Publish(ctx, fmt.Sprintf("%s.new", buffer.name), []byte(metadata.String()))
+	_, err := buffer.publish(ctx, metadata)
 	if err != nil {
 		logger.Error("could not enqueue metadata",
 			slog.String("component", "buffer"),
@@ -125,6 +143,11 @@ func (buffer *natsStreamingBuffer) Enqueue(ctx context.Context, metadata data.Me
 	return nil
 }
 
+// publish sends the metadata with its id as the message id, so that the stream deduplicates it
+func (buffer *natsStreamingBuffer) publish(ctx context.Context, metadata data.Metadata) (*jetstream.PubAck, error) {
+	return buffer.producer.Publish(ctx, fmt.Sprintf("%s.new", buffer.name), []byte(metadata.String()), jetstream.WithMsgID(metadata.String()))
+}
+
 func (buffer *natsStreamingBuffer) Dequeue(ctx context.Context) (Message, error) {
 	logger := ctx.Value("logger").(*slog.Logger)
 
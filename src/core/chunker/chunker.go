@@ -0,0 +1,120 @@
+package chunker
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	tiktoken "github.com/pkoukk/tiktoken-go"
+	"log/slog"
+)
+
+const (
+	// tokenEncoding approximates the tokenizers of the embedding models, which only matters for sizing the chunks
+	tokenEncoding    = "cl100k_base"
+	defaultMaxTokens = 512
+)
+
+// Chunker splits the data that is too long to be embedded as a whole into chunks, which replace it in the list
+type Chunker interface {
+	Chunk(ctx context.Context, dataList []data.Data) []data.Data
+}
+
+// strategy splits a text into pieces of at most maxTokens tokens
+type strategy func(t *tokenizer, text string) []string
+
+type documentChunker struct {
+	tokenizer     *tokenizer
+	strategy      strategy
+	patchStrategy strategy
+}
+
+// noopChunker keeps every document whole, it is used when no strategy is configured
+type noopChunker struct{}
+
+func (noopChunker) Chunk(ctx context.Context, dataList []data.Data) []data.Data {
+	return dataList
+}
+
+func NewChunker(ctx context.Context, chunkerConfig config.ChunkerConfig) (Chunker, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if chunkerConfig.Strategy == "" && chunkerConfig.PatchStrategy == "" {
+		logger.Info("chunking is disabled", slog.String("component", "chunker"))
+		return noopChunker{}, nil
+	}
+	if chunkerConfig.MaxTokens <= 0 {
+		chunkerConfig.MaxTokens = defaultMaxTokens
+	}
+	if chunkerConfig.Overlap < 0 || chunkerConfig.Overlap >= chunkerConfig.MaxTokens {
+		logger.Error("invalid chunk overlap", slog.String("component", "chunker"), slog.Int("overlap", chunkerConfig.Overlap), slog.Int("maxTokens", chunkerConfig.MaxTokens))
+		return nil, fmt.Errorf("chunk overlap %d has to be between 0 and the max tokens %d", chunkerConfig.Overlap, chunkerConfig.MaxTokens)
+	}
+	if chunkerConfig.PatchStrategy == "" {
+		chunkerConfig.PatchStrategy = chunkerConfig.Strategy
+	}
+
+	encoding, err := tiktoken.GetEncoding(tokenEncoding)
+	if err != nil {
+		logger.Error("could not load the token encoding", slog.String("component", "chunker"), slog.Any("error", err))
+		return nil, err
+	}
+	t := &tokenizer{encoding: encoding, maxTokens: chunkerConfig.MaxTokens, overlap: chunkerConfig.Overlap}
+
+	mailStrategy, err := newStrategy(chunkerConfig.Strategy)
+	if err != nil {
+		logger.Error("unknown chunking strategy", slog.String("component", "chunker"), slog.String("strategy", chunkerConfig.Strategy))
+		return nil, err
+	}
+	patchStrategy, err := newStrategy(chunkerConfig.PatchStrategy)
+	if err != nil {
+		logger.Error("unknown chunking strategy", slog.String("component", "chunker"), slog.String("strategy", chunkerConfig.PatchStrategy))
+		return nil, err
+	}
+
+	logger.Info("created chunker", slog.String("component", "chunker"), slog.String("strategy", chunkerConfig.Strategy), slog.String("patchStrategy", chunkerConfig.PatchStrategy), slog.Int("maxTokens", chunkerConfig.MaxTokens))
+	return &documentChunker{tokenizer: t, strategy: mailStrategy, patchStrategy: patchStrategy}, nil
+}
+
+func newStrategy(name string) (strategy, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "fixed":
+		return fixedWindows, nil
+	case "paragraph":
+		return paragraphs, nil
+	case "diff":
+		return diffHunks, nil
+	default:
+		return nil, fmt.Errorf("chunking strategy %s is not supported", name)
+	}
+}
+
+func (c *documentChunker) Chunk(ctx context.Context, dataList []data.Data) []data.Data {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	chunked := make([]data.Data, 0, len(dataList))
+	for _, d := range dataList {
+		split := c.strategy
+		if _, ok := d.(data.PatchData); ok {
+			split = c.patchStrategy
+		}
+		text := d.String()
+		if split == nil || c.tokenizer.count(text) <= c.tokenizer.maxTokens {
+			chunked = append(chunked, d)
+			continue
+		}
+
+		pieces := split(c.tokenizer, text)
+		if len(pieces) <= 1 {
+			chunked = append(chunked, d)
+			continue
+		}
+		for i, piece := range pieces {
+			chunked = append(chunked, data.ChunkData{Parent: d, Index: i, Total: len(pieces), Text: piece})
+		}
+		logger.Debug("chunked document", slog.String("component", "chunker"), slog.String("id", d.GetMetadata().String()), slog.Int("chunks", len(pieces)))
+	}
+	return chunked
+}
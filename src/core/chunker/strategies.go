@@ -0,0 +1,144 @@
+package chunker
+
+import (
+	tiktoken "github.com/pkoukk/tiktoken-go"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenizer struct {
+	encoding  *tiktoken.Tiktoken
+	maxTokens int
+	overlap   int
+}
+
+func (t *tokenizer) count(text string) int {
+	return len(t.encoding.EncodeOrdinary(text))
+}
+
+// fixedWindows cuts the text into windows of maxTokens tokens, consecutive windows sharing overlap tokens. The tokens
+// are byte sequences that can end inside a character, so the windows are cut at the closest token boundary that is
+// also a character boundary, which keeps every window valid UTF-8
+func fixedWindows(t *tokenizer, text string) []string {
+	tokens := t.encoding.EncodeOrdinary(text)
+	if len(tokens) <= t.maxTokens {
+		return []string{text}
+	}
+	// offsets holds the byte offset of every token boundary in the text
+	offsets := make([]int, len(tokens)+1)
+	for i, token := range tokens {
+		offsets[i+1] = offsets[i] + len(t.encoding.Decode([]int{token}))
+	}
+	runeBoundary := func(i int) bool {
+		return i == len(tokens) || utf8.RuneStart(text[offsets[i]])
+	}
+
+	step := t.maxTokens - t.overlap
+	windows := make([]string, 0, len(tokens)/step+1)
+	for start := 0; ; {
+		end := min(start+t.maxTokens, len(tokens))
+		for end > start+1 && !runeBoundary(end) {
+			end--
+		}
+		// a character made of more tokens than the window holds makes the window longer instead
+		for !runeBoundary(end) {
+			end++
+		}
+		windows = append(windows, text[offsets[start]:offsets[end]])
+		if end == len(tokens) {
+			break
+		}
+		next := end - t.overlap
+		for next < end && (next <= start || !runeBoundary(next)) {
+			next++
+		}
+		start = next
+	}
+	return windows
+}
+
+// paragraphs packs whole blank line separated paragraphs into chunks, only a paragraph that is too long by itself
+// is cut into fixed windows
+func paragraphs(t *tokenizer, text string) []string {
+	return pack(t, strings.Split(text, "\n\n"), "\n\n")
+}
+
+// diffHunks keeps the hunks of a patch whole, the commit message is chunked by paragraphs and every hunk is
+// prefixed with the header of its file so that a chunk still says what it changes
+func diffHunks(t *tokenizer, text string) []string {
+	lines := strings.Split(text, "\n")
+	start := -1
+	for i, line := range lines {
+		if strings.HasPrefix(line, "diff --git ") {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return paragraphs(t, text)
+	}
+
+	chunks := make([]string, 0)
+	if message := strings.TrimSpace(strings.Join(lines[:start], "\n")); message != "" {
+		chunks = append(chunks, paragraphs(t, message)...)
+	}
+	for _, file := range splitBefore(lines[start:], func(line string) bool { return strings.HasPrefix(line, "diff --git ") }) {
+		hunks := splitBefore(file, func(line string) bool { return strings.HasPrefix(line, "@@") })
+		header := strings.Join(hunks[0], "\n")
+		if len(hunks) == 1 {
+			// binary files, renames and mode changes have no hunks
+			chunks = append(chunks, pack(t, []string{header}, "\n")...)
+			continue
+		}
+		units := make([]string, 0, len(hunks)-1)
+		for _, hunk := range hunks[1:] {
+			units = append(units, strings.Join(hunk, "\n"))
+		}
+		for _, chunk := range pack(t, units, "\n") {
+			chunks = append(chunks, header+"\n"+chunk)
+		}
+	}
+	return chunks
+}
+
+// splitBefore splits the lines into groups, a new group starting at every line matching start
+func splitBefore(lines []string, start func(line string) bool) [][]string {
+	groups := make([][]string, 0)
+	current := make([]string, 0)
+	for _, line := range lines {
+		if start(line) && len(current) > 0 {
+			groups = append(groups, current)
+			current = make([]string, 0)
+		}
+		current = append(current, line)
+	}
+	return append(groups, current)
+}
+
+// pack greedily joins consecutive units into chunks of at most maxTokens tokens
+func pack(t *tokenizer, units []string, separator string) []string {
+	chunks := make([]string, 0)
+	current := ""
+	for _, unit := range units {
+		if strings.TrimSpace(unit) == "" {
+			continue
+		}
+		if current != "" && t.count(current+separator+unit) <= t.maxTokens {
+			current += separator + unit
+			continue
+		}
+		if current != "" {
+			chunks = append(chunks, current)
+			current = ""
+		}
+		if t.count(unit) > t.maxTokens {
+			chunks = append(chunks, fixedWindows(t, unit)...)
+			continue
+		}
+		current = unit
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
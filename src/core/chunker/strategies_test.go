@@ -0,0 +1,115 @@
+package chunker
+
+import (
+	"context"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	tiktoken "github.com/pkoukk/tiktoken-go"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func testContext() context.Context {
+	return context.WithValue(context.Background(), "logger", slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+// byteTokenizer encodes every byte as a token of its own, so that every character beyond ASCII spans several tokens
+// the way the rare characters of cl100k do. The real encoding is downloaded on first use, which tests cannot rely on
+func byteTokenizer(t *testing.T, maxTokens int, overlap int) *tokenizer {
+	ranks := make(map[string]int, 256)
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	pattern := `\S+|\s+`
+	bpe, err := tiktoken.NewCoreBPE(ranks, map[string]int{}, pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoding := tiktoken.NewTiktoken(bpe, &tiktoken.Encoding{Name: "bytes", PatStr: pattern, MergeableRanks: ranks}, map[string]any{})
+	return &tokenizer{encoding: encoding, maxTokens: maxTokens, overlap: overlap}
+}
+
+func TestFixedWindowsKeepCharactersWhole(t *testing.T) {
+	text := strings.Repeat("日本語のメール, Zoë 🐧 ", 20)
+	for _, overlap := range []int{0, 1, 3} {
+		windows := fixedWindows(byteTokenizer(t, 7, overlap), text)
+		if len(windows) < 2 {
+			t.Fatalf("cut into %d windows, want several", len(windows))
+		}
+		for i, window := range windows {
+			if !utf8.ValidString(window) {
+				t.Fatalf("window %d %q is not valid UTF-8", i, window)
+			}
+			if len(window) > 7 {
+				t.Errorf("window %d %q is longer than the 7 tokens of a window", i, window)
+			}
+		}
+		if !strings.HasPrefix(text, windows[0]) || !strings.HasSuffix(text, windows[len(windows)-1]) {
+			t.Errorf("windows do not start and end with the text")
+		}
+		if joined := strings.Join(windows, ""); overlap == 0 && joined != text {
+			t.Errorf("windows without overlap join into %q", joined)
+		}
+	}
+
+	// a character longer than the window is kept whole in a window of its own
+	windows := fixedWindows(byteTokenizer(t, 2, 0), "🐧🐧")
+	if len(windows) != 2 || windows[0] != "🐧" || windows[1] != "🐧" {
+		t.Errorf("cut into %q", windows)
+	}
+}
+
+func TestPack(t *testing.T) {
+	tk := byteTokenizer(t, 10, 0)
+	chunks := paragraphs(tk, "one\n\ntwo\n\n\n\nthree four five")
+	want := []string{"one\n\ntwo", "three four", " five"}
+	if len(chunks) != len(want) {
+		t.Fatalf("packed into %q, want %q", chunks, want)
+	}
+	for i := range want {
+		if chunks[i] != want[i] {
+			t.Errorf("chunk %d is %q, want %q", i, chunks[i], want[i])
+		}
+	}
+}
+
+func TestDiffHunks(t *testing.T) {
+	patch := "Fix the leak.\n" +
+		"diff --git a/a.c b/a.c\n" +
+		"@@ -1 +1 @@\n" +
+		"-x\n" +
+		"+y\n" +
+		"@@ -9 +9 @@\n" +
+		"-z\n" +
+		"+w"
+	chunks := diffHunks(byteTokenizer(t, 30, 0), patch)
+	want := []string{
+		"Fix the leak.",
+		"diff --git a/a.c b/a.c\n@@ -1 +1 @@\n-x\n+y",
+		"diff --git a/a.c b/a.c\n@@ -9 +9 @@\n-z\n+w",
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("cut into %q, want %q", chunks, want)
+	}
+	for i := range want {
+		if chunks[i] != want[i] {
+			t.Errorf("chunk %d is %q, want %q", i, chunks[i], want[i])
+		}
+	}
+}
+
+func TestNewChunkerRejectsInvalidConfig(t *testing.T) {
+	ctx := testContext()
+	if _, err := NewChunker(ctx, config.ChunkerConfig{Strategy: "fixed", MaxTokens: 10, Overlap: 10}); err == nil {
+		t.Error("overlap as large as the window was accepted")
+	}
+	chunker, err := NewChunker(ctx, config.ChunkerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := chunker.(noopChunker); !ok {
+		t.Errorf("chunker without a strategy is a %T", chunker)
+	}
+}
@@ -0,0 +1,120 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Cleaner strips the parts of the data that should not influence its embedding, keeping the original text around
+type Cleaner interface {
+	Clean(ctx context.Context, dataList []data.Data) []data.Data
+}
+
+type step struct {
+	apply func(text string) string
+	// applyPatch is the variant of the step that cannot cut into a diff, patches skip the steps without one
+	applyPatch func(text string) string
+}
+
+type mailCleaner struct {
+	steps []step
+}
+
+func NewCleaner(ctx context.Context, cleanerConfig config.CleanerConfig) (Cleaner, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	disclaimerPatterns, err := compilePatterns(defaultDisclaimerPatterns, cleanerConfig.DisclaimerPatterns)
+	if err != nil {
+		logger.Error("invalid disclaimer pattern", slog.String("component", "cleaner"), slog.Any("error", err))
+		return nil, err
+	}
+	footerPatterns, err := compilePatterns(defaultFooterPatterns, cleanerConfig.FooterPatterns)
+	if err != nil {
+		logger.Error("invalid footer pattern", slog.String("component", "cleaner"), slog.Any("error", err))
+		return nil, err
+	}
+
+	steps := make([]step, 0, len(cleanerConfig.Steps))
+	for _, name := range cleanerConfig.Steps {
+		switch name {
+		case "quotes":
+			steps = append(steps, step{apply: stripQuotes})
+		case "signatures":
+			steps = append(steps, step{apply: stripSignature, applyPatch: stripPatchSignature})
+		case "disclaimers":
+			steps = append(steps, step{apply: func(text string) string { return stripParagraphs(text, disclaimerPatterns) }})
+		case "footers":
+			footers := func(text string) string { return stripFooters(text, footerPatterns) }
+			steps = append(steps, step{apply: footers, applyPatch: footers})
+		default:
+			logger.Error("unknown cleaning step", slog.String("component", "cleaner"), slog.String("step", name))
+			return nil, fmt.Errorf("cleaning step %s is not supported", name)
+		}
+	}
+
+	logger.Info("created cleaner", slog.String("component", "cleaner"), slog.Any("steps", cleanerConfig.Steps))
+	return &mailCleaner{steps: steps}, nil
+}
+
+func (c *mailCleaner) Clean(ctx context.Context, dataList []data.Data) []data.Data {
+	if len(c.steps) == 0 {
+		return dataList
+	}
+	cleaned := make([]data.Data, 0, len(dataList))
+	for _, d := range dataList {
+		switch m := d.(type) {
+		case data.MailData:
+			cleaned = append(cleaned, c.cleanMail(ctx, m, false))
+		case data.PatchData:
+			m.MailData = c.cleanMail(ctx, m.MailData, true)
+			cleaned = append(cleaned, m)
+		default:
+			cleaned = append(cleaned, d)
+		}
+	}
+	return cleaned
+}
+
+func (c *mailCleaner) cleanMail(ctx context.Context, mail data.MailData, patch bool) data.MailData {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if mail.Original == "" {
+		mail.Original = mail.Data
+	}
+	text := strings.ReplaceAll(mail.Original, "\r\n", "\n")
+	for _, s := range c.steps {
+		apply := s.apply
+		if patch {
+			apply = s.applyPatch
+		}
+		if apply == nil {
+			continue
+		}
+		text = apply(text)
+	}
+	text = collapseBlankLines(text)
+	// a mail that is nothing but quotes is still better embedded as is than not at all
+	if text == "" {
+		logger.Debug("cleaning removed the whole body, keeping the original", slog.String("component", "cleaner"), slog.String("id", mail.Metadata.Id))
+		text = mail.Original
+	}
+	mail.Data = text
+	return mail
+}
+
+func compilePatterns(defaults []string, extra []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(defaults)+len(extra))
+	for _, pattern := range append(append([]string{}, defaults...), extra...) {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, compiled)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,96 @@
+package cleaner
+
+import (
+	"context"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func testContext() context.Context {
+	return context.WithValue(context.Background(), "logger", slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestCleanMail(t *testing.T) {
+	ctx := testContext()
+	cleaner, err := NewCleaner(ctx, config.CleanerConfig{Steps: []string{"quotes", "signatures", "disclaimers", "footers"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := "Agreed, let's merge it.\r\n\r\n" +
+		"On Mon, 1 Jan 2024, John Roe wrote:\r\n" +
+		"> Should we merge this?\r\n" +
+		"\r\n" +
+		"This e-mail is confidential and intended solely for the addressee.\r\n" +
+		"\r\n" +
+		"--\r\n" +
+		"Jane\r\n"
+	cleaned := cleaner.Clean(ctx, []data.Data{data.MailData{Data: body}})
+	mail := cleaned[0].(data.MailData)
+	if mail.Data != "Agreed, let's merge it." {
+		t.Errorf("cleaned to %q", mail.Data)
+	}
+	if mail.Original != body {
+		t.Error("the original body was not kept")
+	}
+
+	// a mail made of quotes only is kept as it is
+	quoted := "> a quote\n> of a quote\n"
+	if mail := cleaner.Clean(ctx, []data.Data{data.MailData{Data: quoted}})[0].(data.MailData); mail.Data != quoted {
+		t.Errorf("quote only mail cleaned to %q", mail.Data)
+	}
+}
+
+func TestCleanPatch(t *testing.T) {
+	ctx := testContext()
+	cleaner, err := NewCleaner(ctx, config.CleanerConfig{Steps: []string{"quotes", "signatures"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the diff removes a line that read "-", which is not a signature, and its context lines start with ">"
+	diff := "diff --git a/list.txt b/list.txt\n" +
+		"@@ -1,3 +1,2 @@\n" +
+		"> item\n" +
+		"--\n" +
+		" end"
+	patch := data.PatchData{MailData: data.MailData{Data: diff + "\n-- \n2.43.0\n"}}
+	cleaned := cleaner.Clean(ctx, []data.Data{patch})[0].(data.PatchData)
+	if cleaned.Data != diff {
+		t.Errorf("patch cleaned to %q, want %q", cleaned.Data, diff)
+	}
+}
+
+func TestStripSignature(t *testing.T) {
+	tests := map[string]string{
+		"body\n-- \nJane":    "body",
+		"body\n--\nJane":     "body",
+		"a\n-- \nb\n-- \nc":  "a\n-- \nb",
+		"body\nno delimiter": "body\nno delimiter",
+	}
+	for text, want := range tests {
+		if got := stripSignature(text); got != want {
+			t.Errorf("stripSignature(%q) = %q, want %q", text, got, want)
+		}
+	}
+	if got := stripPatchSignature("diff\n--\n-- \nsig"); got != "diff\n--" {
+		t.Errorf("stripPatchSignature cut to %q", got)
+	}
+	long := "body\n-- \n"
+	for i := 0; i < maxSignatureLines+1; i++ {
+		long += "line\n"
+	}
+	if got := stripSignature(long); got != long {
+		t.Error("a delimiter too far from the end cut the body")
+	}
+}
+
+func TestNewCleanerRejectsUnknownSteps(t *testing.T) {
+	if _, err := NewCleaner(testContext(), config.CleanerConfig{Steps: []string{"emoji"}}); err == nil {
+		t.Error("unknown step was accepted")
+	}
+	if _, err := NewCleaner(testContext(), config.CleanerConfig{FooterPatterns: []string{"("}}); err == nil {
+		t.Error("invalid pattern was accepted")
+	}
+}
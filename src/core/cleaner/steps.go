@@ -0,0 +1,141 @@
+package cleaner
+
+import (
+	"regexp"
+	"strings"
+)
+
+// maxSignatureLines bounds what is cut after a signature delimiter, so that a stray "-- " in the middle of a mail
+// does not take the rest of the body with it
+const maxSignatureLines = 15
+
+var (
+	// attribution matches the line introducing a quote, e.g. "On Mon, 1 Jan 2024, Jane Doe wrote:"
+	attribution = regexp.MustCompile(`(?i)(wrote|writes|said|schrieb|a écrit)\s*:\s*$`)
+	// footerSeparator is the line list managers put above their footer
+	footerSeparator = regexp.MustCompile(`^\s*_{10,}\s*$`)
+
+	defaultDisclaimerPatterns = []string{
+		`(?i)^\s*(confidentiality notice|disclaimer)\b`,
+		`(?i)this (e-?mail|message|communication)( and any (files|attachments)[^.]*)? (is|are|may be|contains?) (confidential|privileged|intended (solely|only))`,
+		`(?i)if you (are not|have received this)[^.]*(intended recipient|in error)`,
+	}
+	defaultFooterPatterns = []string{
+		`(?im)^[\w.-]+ mailing list\s*$`,
+		`(?i)to unsubscribe`,
+		`(?i)https?://\S*/(mailman/)?listinfo/`,
+		`(?i)https?://vger\.kernel\.org/majordomo-info\.html`,
+		`(?i)^\s*you received this message because you are subscribed`,
+	}
+)
+
+// stripQuotes removes the ">" quoted lines together with the attribution line that introduces them
+func stripQuotes(text string) string {
+	lines := strings.Split(text, "\n")
+	kept := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if isQuoted(line) {
+			continue
+		}
+		if attribution.MatchString(line) && nextQuoted(lines, i+1) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+func isQuoted(line string) bool {
+	return strings.HasPrefix(strings.TrimLeft(line, " \t"), ">")
+}
+
+// nextQuoted reports whether the next non-blank line starting at index is quoted
+func nextQuoted(lines []string, index int) bool {
+	for ; index < len(lines); index++ {
+		if strings.TrimSpace(lines[index]) == "" {
+			continue
+		}
+		return isQuoted(lines[index])
+	}
+	return false
+}
+
+// stripSignature removes everything after the last "-- " signature delimiter, or the "--" it is often trimmed to
+func stripSignature(text string) string {
+	return cutSignature(text, func(line string) bool { return line == "-- " || line == "--" })
+}
+
+// stripPatchSignature only cuts at the exact RFC 3676 delimiter, as a bare "--" line in a diff is a removed line
+// that read "-"
+func stripPatchSignature(text string) string {
+	return cutSignature(text, func(line string) bool { return line == "-- " })
+}
+
+func cutSignature(text string, delimiter func(line string) bool) string {
+	lines := strings.Split(text, "\n")
+	for i := len(lines) - 1; i >= 0 && len(lines)-i <= maxSignatureLines+1; i-- {
+		if delimiter(lines[i]) {
+			return strings.Join(lines[:i], "\n")
+		}
+	}
+	return text
+}
+
+// stripParagraphs removes the blank line separated paragraphs matching any of the patterns
+func stripParagraphs(text string, patterns []*regexp.Regexp) string {
+	paragraphs := strings.Split(text, "\n\n")
+	kept := make([]string, 0, len(paragraphs))
+	for _, paragraph := range paragraphs {
+		if matchesAny(paragraph, patterns) {
+			continue
+		}
+		kept = append(kept, paragraph)
+	}
+	return strings.Join(kept, "\n\n")
+}
+
+// stripFooters removes a footer block below a separator line, along with footer paragraphs at the end of the text
+func stripFooters(text string, patterns []*regexp.Regexp) string {
+	lines := strings.Split(text, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if footerSeparator.MatchString(lines[i]) && matchesAny(strings.Join(lines[i+1:], "\n"), patterns) {
+			lines = lines[:i]
+			break
+		}
+	}
+
+	paragraphs := strings.Split(strings.Join(lines, "\n"), "\n\n")
+	for len(paragraphs) > 0 && matchesAny(paragraphs[len(paragraphs)-1], patterns) {
+		paragraphs = paragraphs[:len(paragraphs)-1]
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func matchesAny(text string, patterns []*regexp.Regexp) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// collapseBlankLines squashes the runs of blank lines that the removals leave behind
+func collapseBlankLines(text string) string {
+	lines := strings.Split(text, "\n")
+	kept := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			if blank {
+				continue
+			}
+			blank = true
+			kept = append(kept, "")
+			continue
+		}
+		blank = false
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
@@ -26,6 +26,8 @@ type Config struct {
 	Storage     []RawStorage      `yaml:"storage"`
 	Engine      RawEngine         `yaml:"engine"`
 	LLM         RawLLM            `yaml:"llm"`
+	Cleaner     CleanerConfig     `yaml:"cleaner"`
+	Chunker     ChunkerConfig     `yaml:"chunker"`
 	Application ApplicationConfig `yaml:"application"`
 }
 
@@ -40,6 +42,8 @@ type RawEngine struct {
 	Type   string    `yaml:"type"`
 	Config yaml.Node `yaml:"config"`
 	Value  Engine    `yaml:"value"`
+	// Cache stores the embeddings so that the same text is only embedded once per model
+	Cache EmbeddingCacheConfig `yaml:"cache"`
 }
 
 type RawBuffer struct {
@@ -82,24 +86,162 @@ type QdrantConfig struct {
 	Port       string `yaml:"port"`
 	Collection string `yaml:"collection"`
 	Generator  string `yaml:"generator"`
+	// OnDimensionMismatch is what happens when the collection was created for another embedding size, either
+	// "error", the default, or "recreate", which drops the collection along with all of its points
+	OnDimensionMismatch string       `yaml:"onDimensionMismatch"`
+	Hybrid              HybridConfig `yaml:"hybrid"`
+}
+
+// HybridConfig stores a BM25 sparse vector next to the dense embedding of every point, so that Fetch can match the
+// identifiers, symbols and paths that dense embeddings handle poorly
+type HybridConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Fusion combines the dense and the sparse rankings, either "rrf", the default, or "dbsf"
+	Fusion string `yaml:"fusion"`
+	// Prefetch is the number of candidates each of the searches contributes to the fusion, 3 times the count when zero
+	Prefetch int `yaml:"prefetch"`
+	// AverageLength is the average document length in terms assumed by the BM25 length normalisation, 256 when zero
+	AverageLength float64 `yaml:"averageLength"`
+}
+
+// PgVectorConfig stores the documents in a postgres table with a pgvector column
+type PgVectorConfig struct {
+	Postgres PostgresConfig `yaml:"postgres"`
+	// Collection is the name of the table, it is created along with its index when it does not exist
+	Collection string `yaml:"collection"`
+	Generator  string `yaml:"generator"`
+	// OnDimensionMismatch is what happens when the table was created for another embedding size, either "error",
+	// the default, or "recreate", which drops the table along with all of its rows
+	OnDimensionMismatch string `yaml:"onDimensionMismatch"`
+}
+
+// MemoryConfig keeps the documents in the memory of the process, for local development and tests
+type MemoryConfig struct {
+	Collection string `yaml:"collection"`
+	Generator  string `yaml:"generator"`
+	// Distance is the similarity the search ranks by, either "cosine", the default, or "dot"
+	Distance string `yaml:"distance"`
+	// Snapshot is the path of the file the points are kept in, which the ingestor and the processor share. It is
+	// reloaded before a read or write when another process has changed it and written after every change, under a
+	// lock on the file next to it. The points are only kept in the memory of one process when it is empty
+	Snapshot string `yaml:"snapshot"`
+	// OnDimensionMismatch is what happens when the snapshot holds vectors of another embedding size, either "error",
+	// the default, or "recreate", which drops all of the points
+	OnDimensionMismatch string `yaml:"onDimensionMismatch"`
 }
 
 type GmailConfig struct {
-	Filters      string `yaml:"filters"`
-	ClientID     string `yaml:"clientID"`
-	ClientSecret string `yaml:"clientSecret"`
-	RefreshToken string `yaml:"refreshToken"`
+	Filters      FilterRule `yaml:"filters"`
+	ClientID     string     `yaml:"clientID"`
+	ClientSecret string     `yaml:"clientSecret"`
+	RefreshToken string     `yaml:"refreshToken"`
+	// Backfill is the newer_than window used when there is no usable history checkpoint, e.g. "1d" or "2w"
+	Backfill string `yaml:"backfill"`
+	// Checkpoint is the path of the file the last ingested history ID is persisted to
+	Checkpoint string `yaml:"checkpoint"`
+}
+
+// FilterRule is a predicate over the headers of a mail. A rule either combines other rules with all, any or not, or
+// matches a single field. A plain string is the legacy form, matching mails whose X-Mailing-List header contains it
+type FilterRule struct {
+	All []FilterRule `yaml:"all"`
+	Any []FilterRule `yaml:"any"`
+	Not *FilterRule  `yaml:"not"`
+	// Field is a header name or one of the shorthands "from", "to", "cc", "subject" and "listID"
+	Field string `yaml:"field"`
+	// only one of the matchers is used, contains, equals and glob ignore the case
+	Contains string `yaml:"contains"`
+	Equals   string `yaml:"equals"`
+	Regex    string `yaml:"regex"`
+	Glob     string `yaml:"glob"`
+}
+
+type ImapConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Security is one of "tls" (default), "starttls" or "none"
+	Security string           `yaml:"security"`
+	Folders  []string         `yaml:"folders"`
+	Search   ImapSearchConfig `yaml:"search"`
+	// Checkpoint is the path of the file the last ingested UID of every folder is persisted to
+	Checkpoint string `yaml:"checkpoint"`
+}
+
+// ImapSearchConfig is translated into the IMAP SEARCH criteria, all the set fields have to match
+type ImapSearchConfig struct {
+	// Since is either a duration relative to now, e.g. "72h", or a date in the "2006-01-02" format
+	Since   string            `yaml:"since"`
+	From    []string          `yaml:"from"`
+	To      []string          `yaml:"to"`
+	Subject []string          `yaml:"subject"`
+	Text    []string          `yaml:"text"`
+	Header  map[string]string `yaml:"header"`
+	Unseen  bool              `yaml:"unseen"`
+}
+
+type FileConfig struct {
+	// Path is a single mbox file, a Maildir or a directory that is walked for both
+	Path string `yaml:"path"`
+	// Format is one of "mbox", "maildir" or "auto" (default), where auto detects the format of every file
+	Format string `yaml:"format"`
+}
+
+type PublicInboxConfig struct {
+	// Path is the inbox directory of a public-inbox v2 clone, containing the git/<epoch>.git repositories
+	Path string `yaml:"path"`
+	// List is the name of the mailing list stored with every message, e.g. "linux-kernel"
+	List string `yaml:"list"`
+	// Checkpoint is the path of the file the last ingested commit of every epoch is persisted to
+	Checkpoint string `yaml:"checkpoint"`
 }
 
 type OllamaConfig struct {
 	Model    string `yaml:"model"`
 	Endpoint string `yaml:"endpoint"`
+	// BatchSize is the number of texts embedded per request and Concurrency the number of requests in flight
+	BatchSize   int `yaml:"batchSize"`
+	Concurrency int `yaml:"concurrency"`
+	// Timeout bounds every request, e.g. "60s"
+	Timeout string `yaml:"timeout"`
+	// Retries is the number of times a request failing with a transient error is retried, 3 when zero and none when
+	// negative. Every retry waits a random time up to Backoff, which doubles on every attempt
+	Retries int    `yaml:"retries"`
+	Backoff string `yaml:"backoff"`
+	// after BreakerThreshold consecutive failed requests, requests are paused for BreakerCooldown
+	BreakerThreshold int    `yaml:"breakerThreshold"`
+	BreakerCooldown  string `yaml:"breakerCooldown"`
+}
+
+// OpenAIEmbeddingConfig configures an embedding engine speaking the OpenAI embeddings API
+type OpenAIEmbeddingConfig struct {
+	// BaseURL is the API root including the version, e.g. "http://localhost:8080/v1", it defaults to OpenAI
+	BaseURL string `yaml:"baseURL"`
+	APIKey  string `yaml:"apikey"`
+	Model   string `yaml:"model"`
+	// Dimensions shortens the embeddings of the models that support it, the model default is used when zero
+	Dimensions int `yaml:"dimensions"`
+	// BatchSize is the number of texts embedded per request and Concurrency the number of requests in flight
+	BatchSize   int `yaml:"batchSize"`
+	Concurrency int `yaml:"concurrency"`
+}
+
+// HashEngineConfig configures the offline engine that derives vectors from the text itself
+type HashEngineConfig struct {
+	// Dimensions is the size of the vectors, it defaults to 768
+	Dimensions int `yaml:"dimensions"`
+	// NGrams is the longest word n-gram hashed into the vector, it defaults to 2
+	NGrams int `yaml:"ngrams"`
 }
 
 type NatsConfig struct {
 	Host string `yaml:"host"`
 	Port string `yaml:"port"`
 	Name string `yaml:"name"`
+	// DuplicateWindow is how long the stream drops a message enqueued again for the same document, e.g. "48h". It
+	// defaults to 48h, which covers sources that fetch the last day on every run
+	DuplicateWindow string `yaml:"duplicateWindow"`
 }
 
 type MinioConfig struct {
@@ -115,11 +257,67 @@ type OpenAIConfig struct {
 	Model  string `yaml:"model"`
 }
 
+// EmbeddingCacheConfig selects where embeddings are cached, caching is disabled when Backend is empty
+type EmbeddingCacheConfig struct {
+	// Backend is either "file" or "postgres"
+	Backend string `yaml:"backend"`
+	// Path is the directory of the file backend
+	Path     string         `yaml:"path"`
+	Postgres PostgresConfig `yaml:"postgres"`
+	// PurgeOtherModels drops the entries of other models when the cache is opened, to reclaim their space. The keys
+	// include the model, so the entries never mix, and the purge must stay off while several models share the cache,
+	// e.g. during a migration. The file backend does not record the model of an entry and drops all of them
+	PurgeOtherModels bool `yaml:"purgeOtherModels"`
+}
+
+// ChunkerConfig selects how data too long to embed as a whole is split into chunks
+type ChunkerConfig struct {
+	// Strategy is one of "fixed", "paragraph" and "diff", the data is not chunked when it is empty
+	Strategy string `yaml:"strategy"`
+	// PatchStrategy is the strategy used for patches, it defaults to Strategy
+	PatchStrategy string `yaml:"patchStrategy"`
+	// MaxTokens is the size of a chunk, data that fits is stored as a single point
+	MaxTokens int `yaml:"maxTokens"`
+	// Overlap is the number of tokens shared by consecutive windows of the fixed strategy
+	Overlap int `yaml:"overlap"`
+}
+
+// CleanerConfig selects the cleaning steps applied to the text of the data before it is embedded
+type CleanerConfig struct {
+	// Steps are any of "quotes", "signatures", "disclaimers" and "footers", applied in order
+	Steps []string `yaml:"steps"`
+	// DisclaimerPatterns are regular expressions matching disclaimer paragraphs, in addition to the built-in ones
+	DisclaimerPatterns []string `yaml:"disclaimerPatterns"`
+	// FooterPatterns are regular expressions matching list footer paragraphs, in addition to the built-in ones
+	FooterPatterns []string `yaml:"footerPatterns"`
+}
+
 type ApplicationConfig struct {
-	EmbeddingSize     int `yaml:"embeddingSize"`
 	IngestionRoutines int `yaml:"ingestionRoutines"`
 	MaxPromptTokens   int `yaml:"maxPromptTokens"`
 	MaxUsageTokes     int `yaml:"maxUsageTokens"`
+	// MaxContextAge drops retrieved context older than this duration, e.g. "720h", it is unbounded when empty
+	MaxContextAge string `yaml:"maxContextAge"`
+}
+
+func (fr *FilterRule) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var legacy string
+		if err := value.Decode(&legacy); err != nil {
+			return err
+		}
+		*fr = FilterRule{Field: "X-Mailing-List", Contains: legacy}
+		return nil
+	}
+
+	// decoding through an alias type avoids recursing into this method
+	type rawFilterRule FilterRule
+	var tmp rawFilterRule
+	if err := value.Decode(&tmp); err != nil {
+		return fmt.Errorf("error decoding filter rule: %w", err)
+	}
+	*fr = FilterRule(tmp)
+	return nil
 }
 
 func (rd *RawSink) UnmarshalYAML(value *yaml.Node) error {
@@ -144,6 +342,20 @@ func (rd *RawSink) UnmarshalYAML(value *yaml.Node) error {
 		}
 		rd.Value = cfg
 
+	case "pgvector":
+		var cfg PgVectorConfig
+		if err := tmp.Config.Decode(&cfg); err != nil {
+			return fmt.Errorf("error decoding pgvector config: %w", err)
+		}
+		rd.Value = cfg
+
+	case "memory":
+		var cfg MemoryConfig
+		if err := tmp.Config.Decode(&cfg); err != nil {
+			return fmt.Errorf("error decoding memory config: %w", err)
+		}
+		rd.Value = cfg
+
 	default:
 		return fmt.Errorf("unsupported sink type: %s", tmp.Type)
 	}
@@ -199,6 +411,24 @@ func (rs *RawSource) UnmarshalYAML(value *yaml.Node) error {
 			return fmt.Errorf("error decoding gmail config: %w", err)
 		}
 		rs.Value = cfg
+	case "imap":
+		var cfg ImapConfig
+		if err := tmp.Config.Decode(&cfg); err != nil {
+			return fmt.Errorf("error decoding imap config: %w", err)
+		}
+		rs.Value = cfg
+	case "file":
+		var cfg FileConfig
+		if err := tmp.Config.Decode(&cfg); err != nil {
+			return fmt.Errorf("error decoding file config: %w", err)
+		}
+		rs.Value = cfg
+	case "public-inbox":
+		var cfg PublicInboxConfig
+		if err := tmp.Config.Decode(&cfg); err != nil {
+			return fmt.Errorf("error decoding public-inbox config: %w", err)
+		}
+		rs.Value = cfg
 	default:
 		return fmt.Errorf("unsupported source type: %s", tmp.Type)
 	}
@@ -236,8 +466,9 @@ func (rs *RawStorage) UnmarshalYAML(value *yaml.Node) error {
 
 func (rs *RawEngine) UnmarshalYAML(value *yaml.Node) error {
 	var tmp struct {
-		Type   string    `yaml:"type"`
-		Config yaml.Node `yaml:"config"`
+		Type   string               `yaml:"type"`
+		Config yaml.Node            `yaml:"config"`
+		Cache  EmbeddingCacheConfig `yaml:"cache"`
 	}
 
 	if err := value.Decode(&tmp); err != nil {
@@ -246,6 +477,7 @@ func (rs *RawEngine) UnmarshalYAML(value *yaml.Node) error {
 
 	rs.Type = tmp.Type
 	rs.Config = tmp.Config
+	rs.Cache = tmp.Cache
 
 	switch tmp.Type {
 	case "ollama":
@@ -254,6 +486,18 @@ func (rs *RawEngine) UnmarshalYAML(value *yaml.Node) error {
 			return fmt.Errorf("error decoding ollama config: %w", err)
 		}
 		rs.Value = cfg
+	case "openai":
+		var cfg OpenAIEmbeddingConfig
+		if err := tmp.Config.Decode(&cfg); err != nil {
+			return fmt.Errorf("error decoding openai config: %w", err)
+		}
+		rs.Value = cfg
+	case "hash":
+		var cfg HashEngineConfig
+		if err := tmp.Config.Decode(&cfg); err != nil {
+			return fmt.Errorf("error decoding hash config: %w", err)
+		}
+		rs.Value = cfg
 	default:
 		return fmt.Errorf("unsupported source type: %s", tmp.Type)
 	}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"github.com/qdrant/go-client/qdrant"
+	"time"
+)
+
+// chunkBodyFields are the payload fields holding the whole text of the parent, which only the first chunk carries
+var chunkBodyFields = []string{"data", "original", "commit_message"}
+
+// ChunkData is a part of a document too long to be embedded as a whole. Every chunk is stored as its own point
+// carrying the metadata of the parent, so that filters apply to chunks as they do to whole documents
+type ChunkData struct {
+	Parent Data
+	// Index is the position of the chunk in the parent, Total the number of chunks of the parent
+	Index int
+	Total int
+	Text  string
+}
+
+func (cd ChunkData) String() string {
+	return cd.Text
+}
+
+func (cd ChunkData) GetMetadata() Metadata {
+	return cd.Parent.GetMetadata()
+}
+
+func (cd ChunkData) GetDate() time.Time {
+	return cd.Parent.GetDate()
+}
+
+// Key is the key of the parent, the chunks of a document are told apart by their index
+func (cd ChunkData) Key() string {
+	return cd.Parent.Key()
+}
+
+// QdrantPayload is the payload of the parent with the chunk fields added. Only the first chunk keeps the text of
+// the parent, which is what a search hit on any of the chunks is collapsed back to
+func (cd ChunkData) QdrantPayload() map[string]*qdrant.Value {
+	payload := cd.Parent.QdrantPayload()
+	if cd.Index > 0 {
+		for _, field := range chunkBodyFields {
+			delete(payload, field)
+		}
+	}
+	payload["chunk_index"] = &qdrant.Value{Kind: &qdrant.Value_IntegerValue{IntegerValue: int64(cd.Index)}}
+	payload["chunk_total"] = &qdrant.Value{Kind: &qdrant.Value_IntegerValue{IntegerValue: int64(cd.Total)}}
+	payload["chunk_text"] = &qdrant.Value{Kind: &qdrant.Value_StringValue{StringValue: cd.Text}}
+	return payload
+}
+
+// IsChunk reports whether the payload belongs to a chunk and returns its index
+func IsChunk(payload map[string]*qdrant.Value) (int, bool) {
+	if _, ok := payload["chunk_index"]; !ok {
+		return 0, false
+	}
+	return int(payload["chunk_index"].GetIntegerValue()), true
+}
+
+// EmbeddingText returns the text the point with the payload was embedded from, the chunk text for chunks and the
+// text of the document otherwise
+func EmbeddingText(payload map[string]*qdrant.Value) (string, error) {
+	if _, ok := IsChunk(payload); ok {
+		return payload["chunk_text"].GetStringValue(), nil
+	}
+	d, err := FromQdrantPayload(payload)
+	if err != nil {
+		return "", err
+	}
+	return d.String(), nil
+}
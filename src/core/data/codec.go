@@ -0,0 +1,150 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"github.com/qdrant/go-client/qdrant"
+	"time"
+)
+
+// PayloadVersion is the version of the payload layout written by QdrantPayload. Version 0 payloads predate the
+// version field and only carry the date as unix seconds
+const PayloadVersion = 1
+
+// payloadDecoders rebuild the data of every payload type, a payload without a type is a mail
+var payloadDecoders = map[string]func(r *payloadReader) Data{
+	"mail":  func(r *payloadReader) Data { return decodeMail(r) },
+	"patch": func(r *payloadReader) Data { return decodePatch(r, decodeMail(r)) },
+}
+
+// FromQdrantPayload decodes a payload written by any version of QdrantPayload. Missing fields are left empty and
+// unknown fields are ignored, only fields of the wrong kind and unknown types or versions are errors
+func FromQdrantPayload(payload map[string]*qdrant.Value) (Data, error) {
+	r := &payloadReader{payload: payload}
+	version := r.integer("payload_version")
+	if version > PayloadVersion {
+		return nil, fmt.Errorf("payload version %d is newer than the supported version %d", version, PayloadVersion)
+	}
+	kind := r.string("type")
+	if kind == "" {
+		kind = "mail"
+	}
+	decode, ok := payloadDecoders[kind]
+	if !ok {
+		return nil, fmt.Errorf("payload type %q is not supported", kind)
+	}
+	d := decode(r)
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// dateValues encodes a date both as unix seconds, which is what range filters and sorting use, and as text, which
+// keeps the zone and the sub-second part
+func dateValues(payload map[string]*qdrant.Value, date time.Time) {
+	payload["date"] = &qdrant.Value{Kind: &qdrant.Value_DoubleValue{DoubleValue: float64(date.Unix())}}
+	payload["date_text"] = &qdrant.Value{Kind: &qdrant.Value_StringValue{StringValue: date.Format(time.RFC3339Nano)}}
+}
+
+// payloadReader reads typed fields out of a payload, collecting the errors instead of stopping at the first one
+type payloadReader struct {
+	payload map[string]*qdrant.Value
+	errs    []error
+}
+
+func (r *payloadReader) err() error {
+	return errors.Join(r.errs...)
+}
+
+func (r *payloadReader) wrongKind(key string, value *qdrant.Value, expected string) {
+	r.errs = append(r.errs, fmt.Errorf("payload field %s is a %T, expected a %s", key, value.GetKind(), expected))
+}
+
+// present reports whether the field is set, a null value counts as missing
+func (r *payloadReader) present(key string) (*qdrant.Value, bool) {
+	value, ok := r.payload[key]
+	if !ok || value == nil || value.GetKind() == nil {
+		return nil, false
+	}
+	if _, null := value.GetKind().(*qdrant.Value_NullValue); null {
+		return nil, false
+	}
+	return value, true
+}
+
+func (r *payloadReader) string(key string) string {
+	value, ok := r.present(key)
+	if !ok {
+		return ""
+	}
+	s, ok := value.GetKind().(*qdrant.Value_StringValue)
+	if !ok {
+		r.wrongKind(key, value, "string")
+		return ""
+	}
+	return s.StringValue
+}
+
+func (r *payloadReader) integer(key string) int64 {
+	value, ok := r.present(key)
+	if !ok {
+		return 0
+	}
+	switch v := value.GetKind().(type) {
+	case *qdrant.Value_IntegerValue:
+		return v.IntegerValue
+	case *qdrant.Value_DoubleValue:
+		return int64(v.DoubleValue)
+	default:
+		r.wrongKind(key, value, "number")
+		return 0
+	}
+}
+
+func (r *payloadReader) strings(key string) []string {
+	values := make([]string, 0)
+	value, ok := r.present(key)
+	if !ok {
+		return values
+	}
+	list, ok := value.GetKind().(*qdrant.Value_ListValue)
+	if !ok {
+		r.wrongKind(key, value, "list")
+		return values
+	}
+	for _, v := range list.ListValue.GetValues() {
+		s, ok := v.GetKind().(*qdrant.Value_StringValue)
+		if !ok {
+			r.wrongKind(key, v, "string list")
+			continue
+		}
+		values = append(values, s.StringValue)
+	}
+	return values
+}
+
+// date reads the text form of a date when there is one and the unix seconds of version 0 payloads otherwise
+func (r *payloadReader) date(key string) time.Time {
+	if text := r.string(key + "_text"); text != "" {
+		date, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("payload field %s_text: %w", key, err))
+			return time.Time{}
+		}
+		return date
+	}
+	value, ok := r.present(key)
+	if !ok {
+		return time.Time{}
+	}
+	switch v := value.GetKind().(type) {
+	case *qdrant.Value_DoubleValue:
+		return time.Unix(int64(v.DoubleValue), 0).UTC()
+	case *qdrant.Value_IntegerValue:
+		return time.Unix(v.IntegerValue, 0).UTC()
+	default:
+		r.wrongKind(key, value, "number")
+		return time.Time{}
+	}
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"github.com/qdrant/go-client/qdrant"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMail() MailData {
+	return MailData{
+		Metadata:    MailMetadata{Id: "18c2f", ThreadID: "18c2a"},
+		Sender:      "Jane Doe <jane@example.org>",
+		Date:        time.Date(2024, 5, 1, 12, 30, 15, 250000000, time.FixedZone("CEST", 2*60*60)),
+		Data:        "the cleaned body",
+		Original:    "the body as received",
+		Subject:     "Re: [PATCH] mm: fix a leak",
+		List:        "linux-mm",
+		MessageID:   "reply@example.org",
+		InReplyTo:   "patch@example.org",
+		References:  []string{"patch@example.org"},
+		Attachments: []string{},
+		Source:      "imap",
+	}
+}
+
+func TestPayloadRoundTrip(t *testing.T) {
+	mail := testMail()
+	patch := PatchData{
+		MailData:      mail,
+		Version:       3,
+		Index:         2,
+		Total:         7,
+		Tags:          []string{"net-next"},
+		Title:         "net: fix a leak",
+		Files:         []string{"net/core/dev.c"},
+		Insertions:    4,
+		Deletions:     1,
+		CommitMessage: "Free the buffer on the error path.",
+		ReviewedBy:    []string{"John Roe <john@example.org>"},
+		AckedBy:       []string{},
+		TestedBy:      []string{},
+		SignedOffBy:   []string{"Jane Doe <jane@example.org>"},
+	}
+	for _, d := range []Data{mail, patch} {
+		decoded, err := FromQdrantPayload(d.QdrantPayload())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the text form of the date keeps the offset and the sub-second part, though not the name of the zone
+		decodedMail, _ := AsMail(decoded)
+		if decodedMail.Date.Format(time.RFC3339Nano) != mail.Date.Format(time.RFC3339Nano) {
+			t.Errorf("date decoded as %v, want %v", decodedMail.Date, mail.Date)
+		}
+		switch v := decoded.(type) {
+		case MailData:
+			v.Date = mail.Date
+			decoded = v
+		case PatchData:
+			v.Date = mail.Date
+			decoded = v
+		}
+		if !reflect.DeepEqual(decoded, d) {
+			t.Errorf("decoded %#v, want %#v", decoded, d)
+		}
+	}
+}
+
+func TestPayloadVersionZero(t *testing.T) {
+	payload := map[string]*qdrant.Value{
+		"mail_id": {Kind: &qdrant.Value_StringValue{StringValue: "18c2f"}},
+		"sender":  {Kind: &qdrant.Value_StringValue{StringValue: "jane@example.org"}},
+		"date":    {Kind: &qdrant.Value_DoubleValue{DoubleValue: 1714559415}},
+		"data":    {Kind: &qdrant.Value_StringValue{StringValue: "the body"}},
+		"unknown": {Kind: &qdrant.Value_StringValue{StringValue: "ignored"}},
+	}
+	decoded, err := FromQdrantPayload(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail, ok := decoded.(MailData)
+	if !ok {
+		t.Fatalf("decoded a %T, want a mail", decoded)
+	}
+	if want := time.Unix(1714559415, 0).UTC(); !mail.Date.Equal(want) {
+		t.Errorf("date decoded as %v, want %v", mail.Date, want)
+	}
+	if mail.Metadata.Id != "18c2f" || mail.Data != "the body" || len(mail.References) != 0 {
+		t.Errorf("decoded %#v", mail)
+	}
+}
+
+func TestPayloadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]*qdrant.Value
+		want    string
+	}{
+		{"newer version", map[string]*qdrant.Value{
+			"payload_version": {Kind: &qdrant.Value_IntegerValue{IntegerValue: PayloadVersion + 1}},
+		}, "newer than the supported version"},
+		{"unknown type", map[string]*qdrant.Value{
+			"type": {Kind: &qdrant.Value_StringValue{StringValue: "calendar"}},
+		}, `type "calendar" is not supported`},
+		{"wrong kinds", map[string]*qdrant.Value{
+			"sender":     {Kind: &qdrant.Value_IntegerValue{IntegerValue: 1}},
+			"references": {Kind: &qdrant.Value_StringValue{StringValue: "patch@example.org"}},
+		}, "payload field references"},
+		{"broken date text", map[string]*qdrant.Value{
+			"date_text": {Kind: &qdrant.Value_StringValue{StringValue: "yesterday"}},
+		}, "payload field date_text"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := FromQdrantPayload(test.payload)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package data
 
-import "github.com/qdrant/go-client/qdrant"
+import (
+	"github.com/qdrant/go-client/qdrant"
+	"time"
+)
 
 // Metadata is a list of mailMetadata
 type Metadata interface {
@@ -12,4 +15,8 @@ type Data interface {
 	String() string
 	QdrantPayload() map[string]*qdrant.Value
 	GetMetadata() Metadata
+	// GetDate is when the data was created, the zero time when it is unknown
+	GetDate() time.Time
+	// Key identifies the data across ingestions, so that ingesting it again replaces it instead of duplicating it
+	Key() string
 }
@@ -15,6 +15,17 @@ type MailData struct {
 	Sender   string
 	Date     time.Time
 	Data     string
+	// Original is the body as it was received, Data holds the cleaned body when a cleaner is configured
+	Original   string
+	Subject    string
+	List       string
+	MessageID  string
+	InReplyTo  string
+	References []string
+	// Attachments are the file names of the attachments, their content is not ingested
+	Attachments []string
+	// Source is the type of the source the mail was ingested from, e.g. "gmail"
+	Source string
 }
 
 func (mmd MailMetadata) String() string {
@@ -22,13 +33,43 @@ func (mmd MailMetadata) String() string {
 }
 
 func (md MailData) QdrantPayload() map[string]*qdrant.Value {
-	return map[string]*qdrant.Value{
-		"mail_id":   {Kind: &qdrant.Value_StringValue{StringValue: md.Metadata.Id}},
-		"thread_id": {Kind: &qdrant.Value_StringValue{StringValue: md.Metadata.ThreadID}},
-		"sender":    {Kind: &qdrant.Value_StringValue{StringValue: md.Sender}},
-		"date":      {Kind: &qdrant.Value_DoubleValue{DoubleValue: float64(md.Date.Unix())}},
-		"data":      {Kind: &qdrant.Value_StringValue{StringValue: md.Data}},
+	thread := md.Thread()
+	payload := map[string]*qdrant.Value{
+		"payload_version": {Kind: &qdrant.Value_IntegerValue{IntegerValue: PayloadVersion}},
+		"type":            {Kind: &qdrant.Value_StringValue{StringValue: "mail"}},
+		"mail_id":         {Kind: &qdrant.Value_StringValue{StringValue: md.Metadata.Id}},
+		"source":          {Kind: &qdrant.Value_StringValue{StringValue: md.Source}},
+		"thread_id":       {Kind: &qdrant.Value_StringValue{StringValue: md.Metadata.ThreadID}},
+		"sender":          {Kind: &qdrant.Value_StringValue{StringValue: md.Sender}},
+		"data":            {Kind: &qdrant.Value_StringValue{StringValue: md.Data}},
+		"original":        {Kind: &qdrant.Value_StringValue{StringValue: md.Original}},
+		"list":            {Kind: &qdrant.Value_StringValue{StringValue: md.List}},
+		"message_id":      {Kind: &qdrant.Value_StringValue{StringValue: md.MessageID}},
+		"in_reply_to":     {Kind: &qdrant.Value_StringValue{StringValue: md.InReplyTo}},
+		"references":      stringListValue(md.References),
+		"attachments":     stringListValue(md.Attachments),
+		"subject":         {Kind: &qdrant.Value_StringValue{StringValue: md.Subject}},
+		// the thread fields let a sink return a whole conversation
+		"thread_root":        {Kind: &qdrant.Value_StringValue{StringValue: thread.Root}},
+		"thread_parent":      {Kind: &qdrant.Value_StringValue{StringValue: thread.Parent}},
+		"thread_position":    {Kind: &qdrant.Value_IntegerValue{IntegerValue: int64(thread.Position)}},
+		"normalized_subject": {Kind: &qdrant.Value_StringValue{StringValue: thread.Subject}},
 	}
+	dateValues(payload, md.Date)
+	return payload
+}
+
+// Thread links the mail to the conversation it belongs to
+func (md MailData) Thread() Thread {
+	return NewThread(md.MessageID, md.InReplyTo, md.References, md.Subject)
+}
+
+func stringListValue(values []string) *qdrant.Value {
+	list := make([]*qdrant.Value, 0, len(values))
+	for _, value := range values {
+		list = append(list, &qdrant.Value{Kind: &qdrant.Value_StringValue{StringValue: value}})
+	}
+	return &qdrant.Value{Kind: &qdrant.Value_ListValue{ListValue: &qdrant.ListValue{Values: list}}}
 }
 
 func (md MailData) String() string {
@@ -39,14 +80,36 @@ func (md MailData) GetMetadata() Metadata {
 	return md.Metadata
 }
 
-func FromQdrantPayload(payload map[string]*qdrant.Value) Data {
-	// todo: send back date
+func (md MailData) GetDate() time.Time {
+	return md.Date
+}
+
+// Key is the source type with the message id, which survives the source being read again. The id the source
+// assigned is used for the mails without a message id
+func (md MailData) Key() string {
+	id := md.MessageID
+	if id == "" {
+		id = md.Metadata.Id
+	}
+	return md.Source + "\x00" + id
+}
+
+func decodeMail(r *payloadReader) MailData {
 	return MailData{
-		Data: payload["data"].Kind.(*qdrant.Value_StringValue).StringValue,
 		Metadata: MailMetadata{
-			Id:       payload["mail_id"].Kind.(*qdrant.Value_StringValue).StringValue,
-			ThreadID: payload["thread_id"].Kind.(*qdrant.Value_StringValue).StringValue,
+			Id:       r.string("mail_id"),
+			ThreadID: r.string("thread_id"),
 		},
-		Sender: payload["sender"].Kind.(*qdrant.Value_StringValue).StringValue,
+		Source:      r.string("source"),
+		Sender:      r.string("sender"),
+		Date:        r.date("date"),
+		Data:        r.string("data"),
+		Original:    r.string("original"),
+		Subject:     r.string("subject"),
+		List:        r.string("list"),
+		MessageID:   r.string("message_id"),
+		InReplyTo:   r.string("in_reply_to"),
+		References:  r.strings("references"),
+		Attachments: r.strings("attachments"),
 	}
 }
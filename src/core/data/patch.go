@@ -0,0 +1,197 @@
+package data
+
+import (
+	"github.com/qdrant/go-client/qdrant"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// patchSubject matches kernel style patch subjects, e.g. "[PATCH net-next v3 2/7] net: fix a leak"
+	patchSubject = regexp.MustCompile(`^\s*\[([^\]]*\bPATCH\b[^\]]*)\]\s*(.*)$`)
+	patchVersion = regexp.MustCompile(`^[vV](\d+)$`)
+	patchIndex   = regexp.MustCompile(`^(\d+)/(\d+)$`)
+	diffHeader   = regexp.MustCompile(`^diff --git a/(\S+) b/(\S+)`)
+	trailer      = regexp.MustCompile(`^([A-Za-z-]+-by):\s*(.+)$`)
+)
+
+// PatchData is a mail carrying a patch, with the parts of the patch that are useful to filter on
+type PatchData struct {
+	MailData
+	// Version is the revision of the series, 1 when the subject has no "vN" tag
+	Version int
+	// Index and Total locate the patch in its series, both are 1 for a single patch and Index is 0 for a cover letter
+	Index int
+	Total int
+	// Tags are the other words of the subject tag, e.g. "RFC" or "net-next"
+	Tags []string
+	// Title is the subject without the patch tag
+	Title         string
+	Files         []string
+	Insertions    int
+	Deletions     int
+	CommitMessage string
+	ReviewedBy    []string
+	AckedBy       []string
+	TestedBy      []string
+	SignedOffBy   []string
+}
+
+// ParsePatch recognises a mail that carries a patch by its subject tag and parses the patch out of its body.
+// Replies to patches are not patches themselves
+func ParsePatch(mail MailData) (PatchData, bool) {
+	match := patchSubject.FindStringSubmatch(mail.Subject)
+	if match == nil {
+		return PatchData{}, false
+	}
+
+	patch := PatchData{MailData: mail, Version: 1, Index: 1, Total: 1, Title: strings.TrimSpace(match[2])}
+	for _, word := range strings.Fields(match[1]) {
+		if word == "PATCH" {
+			continue
+		}
+		if version := patchVersion.FindStringSubmatch(word); version != nil {
+			patch.Version, _ = strconv.Atoi(version[1])
+			continue
+		}
+		if index := patchIndex.FindStringSubmatch(word); index != nil {
+			patch.Index, _ = strconv.Atoi(index[1])
+			patch.Total, _ = strconv.Atoi(index[2])
+			continue
+		}
+		patch.Tags = append(patch.Tags, word)
+	}
+
+	body := mail.Original
+	if body == "" {
+		body = mail.Data
+	}
+	patch.parseBody(strings.ReplaceAll(body, "\r\n", "\n"))
+	return patch, true
+}
+
+// parseBody splits the body into the commit message and the diff, the commit message ends at the "---" line
+// that precedes the diffstat or at the first diff header
+func (pd *PatchData) parseBody(body string) {
+	lines := strings.Split(body, "\n")
+	inMessage := true
+	inDiff := false
+	message := make([]string, 0)
+	for _, line := range lines {
+		if header := diffHeader.FindStringSubmatch(line); header != nil {
+			inMessage = false
+			inDiff = true
+			pd.Files = append(pd.Files, header[2])
+			continue
+		}
+		if inMessage {
+			if line == "---" {
+				inMessage = false
+				continue
+			}
+			message = append(message, line)
+			if t := trailer.FindStringSubmatch(strings.TrimSpace(line)); t != nil {
+				pd.addTrailer(t[1], strings.TrimSpace(t[2]))
+			}
+			continue
+		}
+		if !inDiff {
+			continue
+		}
+		switch {
+		case line == "-- ":
+			// the signature of git format-patch ends the diff, a bare "--" is a removed line starting with "-"
+			inDiff = false
+		case strings.HasPrefix(line, "+++ ") || strings.HasPrefix(line, "--- "):
+		case strings.HasPrefix(line, "+"):
+			pd.Insertions++
+		case strings.HasPrefix(line, "-"):
+			pd.Deletions++
+		}
+	}
+	pd.CommitMessage = strings.TrimSpace(strings.Join(message, "\n"))
+}
+
+func (pd *PatchData) addTrailer(name string, value string) {
+	switch strings.ToLower(name) {
+	case "reviewed-by":
+		pd.ReviewedBy = append(pd.ReviewedBy, value)
+	case "acked-by":
+		pd.AckedBy = append(pd.AckedBy, value)
+	case "tested-by":
+		pd.TestedBy = append(pd.TestedBy, value)
+	case "signed-off-by":
+		pd.SignedOffBy = append(pd.SignedOffBy, value)
+	}
+}
+
+// Paths are the touched files along with all of their parent directories, so that a filter on a subsystem
+// directory such as "drivers/net" matches every patch below it
+func (pd PatchData) Paths() []string {
+	seen := make(map[string]bool)
+	paths := make([]string, 0)
+	for _, file := range pd.Files {
+		for p := file; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+			if seen[p] {
+				break
+			}
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func (pd PatchData) QdrantPayload() map[string]*qdrant.Value {
+	payload := pd.MailData.QdrantPayload()
+	payload["type"] = &qdrant.Value{Kind: &qdrant.Value_StringValue{StringValue: "patch"}}
+	payload["patch_version"] = &qdrant.Value{Kind: &qdrant.Value_IntegerValue{IntegerValue: int64(pd.Version)}}
+	payload["patch_index"] = &qdrant.Value{Kind: &qdrant.Value_IntegerValue{IntegerValue: int64(pd.Index)}}
+	payload["patch_total"] = &qdrant.Value{Kind: &qdrant.Value_IntegerValue{IntegerValue: int64(pd.Total)}}
+	payload["patch_tags"] = stringListValue(pd.Tags)
+	payload["patch_title"] = &qdrant.Value{Kind: &qdrant.Value_StringValue{StringValue: pd.Title}}
+	payload["files"] = stringListValue(pd.Files)
+	payload["paths"] = stringListValue(pd.Paths())
+	payload["insertions"] = &qdrant.Value{Kind: &qdrant.Value_IntegerValue{IntegerValue: int64(pd.Insertions)}}
+	payload["deletions"] = &qdrant.Value{Kind: &qdrant.Value_IntegerValue{IntegerValue: int64(pd.Deletions)}}
+	payload["commit_message"] = &qdrant.Value{Kind: &qdrant.Value_StringValue{StringValue: pd.CommitMessage}}
+	payload["reviewed_by"] = stringListValue(pd.ReviewedBy)
+	payload["acked_by"] = stringListValue(pd.AckedBy)
+	payload["tested_by"] = stringListValue(pd.TestedBy)
+	payload["signed_off_by"] = stringListValue(pd.SignedOffBy)
+	payload["reviewed"] = &qdrant.Value{Kind: &qdrant.Value_BoolValue{BoolValue: len(pd.ReviewedBy) > 0 || len(pd.AckedBy) > 0}}
+	return payload
+}
+
+func decodePatch(r *payloadReader, mail MailData) PatchData {
+	return PatchData{
+		MailData:      mail,
+		Version:       int(r.integer("patch_version")),
+		Index:         int(r.integer("patch_index")),
+		Total:         int(r.integer("patch_total")),
+		Tags:          r.strings("patch_tags"),
+		Title:         r.string("patch_title"),
+		Files:         r.strings("files"),
+		Insertions:    int(r.integer("insertions")),
+		Deletions:     int(r.integer("deletions")),
+		CommitMessage: r.string("commit_message"),
+		ReviewedBy:    r.strings("reviewed_by"),
+		AckedBy:       r.strings("acked_by"),
+		TestedBy:      r.strings("tested_by"),
+		SignedOffBy:   r.strings("signed_off_by"),
+	}
+}
+
+// AsMail returns the mail of data that is either a mail or a patch
+func AsMail(d Data) (MailData, bool) {
+	switch m := d.(type) {
+	case MailData:
+		return m, true
+	case PatchData:
+		return m.MailData, true
+	default:
+		return MailData{}, false
+	}
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testPatchBody removes a line holding "-", which must not be taken for the signature
+const testPatchBody = `The buffer leaks when the lookup fails.
+
+Reviewed-by: John Roe <john@example.org>
+Signed-off-by: Jane Doe <jane@example.org>
+---
+ net/core/dev.c | 4 ++--
+ 1 file changed, 2 insertions(+), 2 deletions(-)
+
+diff --git a/net/core/dev.c b/net/core/dev.c
+--- a/net/core/dev.c
++++ b/net/core/dev.c
+@@ -10,7 +10,8 @@
+ 	if (!entry)
+-		return -ENOENT;
++		goto free;
++	kfree(buf);
+--
+` + "-- \n" + `2.43.0
+-not a deletion
+`
+
+func TestParsePatch(t *testing.T) {
+	mail := MailData{Subject: "[PATCH net-next v3 2/7] net: free the buffer", Original: testPatchBody}
+	patch, ok := ParsePatch(mail)
+	if !ok {
+		t.Fatal("patch was not recognised")
+	}
+	want := PatchData{
+		MailData:      mail,
+		Version:       3,
+		Index:         2,
+		Total:         7,
+		Tags:          []string{"net-next"},
+		Title:         "net: free the buffer",
+		Files:         []string{"net/core/dev.c"},
+		Insertions:    2,
+		Deletions:     2,
+		CommitMessage: "The buffer leaks when the lookup fails.\n\nReviewed-by: John Roe <john@example.org>\nSigned-off-by: Jane Doe <jane@example.org>",
+		ReviewedBy:    []string{"John Roe <john@example.org>"},
+		SignedOffBy:   []string{"Jane Doe <jane@example.org>"},
+	}
+	if !reflect.DeepEqual(patch, want) {
+		t.Errorf("parsed %#v, want %#v", patch, want)
+	}
+	if paths := patch.Paths(); !reflect.DeepEqual(paths, []string{"net/core/dev.c", "net/core", "net"}) {
+		t.Errorf("paths %v", paths)
+	}
+}
+
+func TestParsePatchSubjects(t *testing.T) {
+	tests := []struct {
+		subject string
+		patch   bool
+		version int
+		index   int
+		total   int
+	}{
+		{"[PATCH] mm: fix a leak", true, 1, 1, 1},
+		{"[RFC PATCH v2 0/3] mm: a new allocator", true, 2, 0, 3},
+		{"Re: [PATCH] mm: fix a leak", false, 0, 0, 0},
+		{"[PATCHES] not a patch tag", false, 0, 0, 0},
+		{"weekly report", false, 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.subject, func(t *testing.T) {
+			patch, ok := ParsePatch(MailData{Subject: test.subject})
+			if ok != test.patch {
+				t.Fatalf("recognised %v, want %v", ok, test.patch)
+			}
+			if patch.Version != test.version || patch.Index != test.index || patch.Total != test.total {
+				t.Errorf("parsed v%d %d/%d, want v%d %d/%d", patch.Version, patch.Index, patch.Total, test.version, test.index, test.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package data
+
+import "maps"
+
+// FieldType is the kind of index a payload field is filtered through
+type FieldType string
+
+const (
+	FieldKeyword  FieldType = "keyword"
+	FieldInteger  FieldType = "integer"
+	FieldFloat    FieldType = "float"
+	FieldBool     FieldType = "bool"
+	FieldDatetime FieldType = "datetime"
+	// FieldText is a field of words, the only kind text conditions apply to
+	FieldText FieldType = "text"
+)
+
+// mailSchema are the fields of the mail payload that filters select on. The bodies are searched by their vectors, an
+// index over them would be as large as the collection, so the subject is what text conditions search. The date is
+// filtered as unix seconds, the text of the date is indexed for the datetime ranges of qdrant
+var mailSchema = map[string]FieldType{
+	"type":            FieldKeyword,
+	"mail_id":         FieldKeyword,
+	"source":          FieldKeyword,
+	"thread_id":       FieldKeyword,
+	"sender":          FieldKeyword,
+	"list":            FieldKeyword,
+	"message_id":      FieldKeyword,
+	"in_reply_to":     FieldKeyword,
+	"references":      FieldKeyword,
+	"subject":         FieldText,
+	"thread_root":     FieldKeyword,
+	"thread_parent":   FieldKeyword,
+	"thread_position": FieldInteger,
+	"date":            FieldFloat,
+	"date_text":       FieldDatetime,
+}
+
+var patchSchema = map[string]FieldType{
+	"patch_version": FieldInteger,
+	"patch_tags":    FieldKeyword,
+	"patch_title":   FieldText,
+	"paths":         FieldKeyword,
+	"reviewed":      FieldBool,
+	"reviewed_by":   FieldKeyword,
+	"acked_by":      FieldKeyword,
+}
+
+var chunkSchema = map[string]FieldType{
+	"chunk_index": FieldInteger,
+}
+
+// PayloadSchema returns the indexed fields of every payload type, as a collection holds the points of all of them
+func PayloadSchema() map[string]FieldType {
+	schema := make(map[string]FieldType)
+	for _, fields := range []map[string]FieldType{mailSchema, patchSchema, chunkSchema} {
+		maps.Copy(schema, fields)
+	}
+	return schema
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"regexp"
+	"strings"
+)
+
+// replyPrefix matches the reply and forward markers that clients prepend to a subject, e.g. "Re:", "Fwd:", "AW:" or "Re[2]:"
+var replyPrefix = regexp.MustCompile(`(?i)^\s*(re|fwd?|aw|sv|antw|wg)\s*(\[\d+\])?\s*:\s*`)
+
+// Thread places a mail within its conversation, built from the In-Reply-To and References headers
+type Thread struct {
+	// Root is the message ID of the mail that started the thread
+	Root string
+	// Parent is the message ID of the mail that this one replies to, empty for the root
+	Parent string
+	// Ancestors are the message IDs from the root down to the parent
+	Ancestors []string
+	// Position is the depth of the mail in the reply chain, the root is at 0
+	Position int
+	// Subject is the subject with the reply and forward markers removed
+	Subject string
+}
+
+// NewThread links a mail to its parent and root. The parent is the In-Reply-To message, falling back to the last
+// reference for clients that only send References
+func NewThread(messageID string, inReplyTo string, references []string, subject string) Thread {
+	ancestors := make([]string, 0, len(references)+1)
+	for _, reference := range references {
+		if reference != "" && reference != messageID {
+			ancestors = append(ancestors, reference)
+		}
+	}
+	if inReplyTo != "" && inReplyTo != messageID && (len(ancestors) == 0 || ancestors[len(ancestors)-1] != inReplyTo) {
+		ancestors = append(ancestors, inReplyTo)
+	}
+
+	thread := Thread{Root: messageID, Ancestors: ancestors, Position: len(ancestors), Subject: NormalizeSubject(subject)}
+	if len(ancestors) > 0 {
+		thread.Root = ancestors[0]
+		thread.Parent = ancestors[len(ancestors)-1]
+	}
+	return thread
+}
+
+// NormalizeSubject strips the reply and forward markers and collapses the whitespace of a subject
+func NormalizeSubject(subject string) string {
+	for {
+		stripped := replyPrefix.ReplaceAllString(subject, "")
+		if stripped == subject {
+			break
+		}
+		subject = stripped
+	}
+	return strings.Join(strings.Fields(subject), " ")
+}
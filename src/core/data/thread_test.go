@@ -0,0 +1,49 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewThread(t *testing.T) {
+	tests := []struct {
+		name       string
+		inReplyTo  string
+		references []string
+		want       Thread
+	}{
+		{"root", "", nil, Thread{Root: "c@x", Ancestors: []string{}, Subject: "mm: fix a leak"}},
+		{"references", "b@x", []string{"a@x", "b@x"},
+			Thread{Root: "a@x", Parent: "b@x", Ancestors: []string{"a@x", "b@x"}, Position: 2, Subject: "mm: fix a leak"}},
+		{"only in-reply-to", "b@x", nil,
+			Thread{Root: "b@x", Parent: "b@x", Ancestors: []string{"b@x"}, Position: 1, Subject: "mm: fix a leak"}},
+		// the parent is In-Reply-To even when a client left it out of References
+		{"truncated references", "b@x", []string{"a@x"},
+			Thread{Root: "a@x", Parent: "b@x", Ancestors: []string{"a@x", "b@x"}, Position: 2, Subject: "mm: fix a leak"}},
+		{"self reference", "c@x", []string{"", "a@x", "c@x"},
+			Thread{Root: "a@x", Parent: "a@x", Ancestors: []string{"a@x"}, Position: 1, Subject: "mm: fix a leak"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thread := NewThread("c@x", test.inReplyTo, test.references, "Re: AW: Re[2]:  mm: fix   a leak")
+			if !reflect.DeepEqual(thread, test.want) {
+				t.Errorf("thread %#v, want %#v", thread, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeSubject(t *testing.T) {
+	tests := map[string]string{
+		"Re: Fwd: weekly report":     "weekly report",
+		"RE:re: weekly report":       "weekly report",
+		"Fw: SV: Antw: WG: report":   "report",
+		"Reply: weekly report":       "Reply: weekly report",
+		"[PATCH] re: mm: fix a leak": "[PATCH] re: mm: fix a leak",
+	}
+	for subject, want := range tests {
+		if got := NormalizeSubject(subject); got != want {
+			t.Errorf("NormalizeSubject(%q) = %q, want %q", subject, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	defaultBatchSize   = 32
+	defaultConcurrency = 4
+)
+
+// embedInBatches splits the texts into batches of batchSize and embeds up to concurrency of them at once, the
+// embeddings are returned in the order of the texts
+func embedInBatches(ctx context.Context, texts []string, batchSize int, concurrency int, embed func(ctx context.Context, batch []string) ([][]float32, error)) ([][]float32, error) {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	embeddings := make([][]float32, len(texts))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for start := 0; start < len(texts); start += batchSize {
+		end := start + batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		semaphore <- struct{}{}
+		if ctx.Err() != nil {
+			<-semaphore
+			break
+		}
+		wg.Add(1)
+		go func(start int, end int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			batch, err := embed(ctx, texts[start:end])
+			if err == nil && len(batch) != end-start {
+				err = fmt.Errorf("expected %d embeddings, got %d", end-start, len(batch))
+			}
+			if err != nil {
+				// the first failure fails the whole call, so the batches still running are cancelled
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			copy(embeddings[start:end], batch)
+		}(start, end)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return embeddings, nil
+}
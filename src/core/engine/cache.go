@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"golang.org/x/text/unicode/norm"
+	"log/slog"
+	"math"
+	"strings"
+	"sync/atomic"
+)
+
+// cacheStore persists embeddings by key. The keys include the model, so the entries of several models can share a
+// store
+type cacheStore interface {
+	get(ctx context.Context, keys []string) (map[string][]float32, error)
+	put(ctx context.Context, entries map[string][]float32) error
+}
+
+// CachedEngine embeds through the wrapped engine only the texts whose embedding is not cached yet
+type CachedEngine struct {
+	engine Engine
+	model  string
+	store  cacheStore
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func NewCachedEngine(ctx context.Context, engine Engine, model string, cacheConfig config.EmbeddingCacheConfig) (*CachedEngine, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	var store cacheStore
+	var err error
+	switch cacheConfig.Backend {
+	case "file":
+		store, err = newFileCacheStore(ctx, cacheConfig.Path, model, cacheConfig.PurgeOtherModels)
+	case "postgres":
+		store, err = newPostgresCacheStore(ctx, cacheConfig.Postgres, model, cacheConfig.PurgeOtherModels)
+	default:
+		logger.Error("unknown embedding cache backend", slog.String("component", "engine"), slog.String("backend", cacheConfig.Backend))
+		return nil, fmt.Errorf("embedding cache backend %s is not supported", cacheConfig.Backend)
+	}
+	if err != nil {
+		logger.Error("could not open the embedding cache", slog.String("component", "engine"), slog.String("backend", cacheConfig.Backend), slog.Any("error", err))
+		return nil, err
+	}
+
+	logger.Info("created embedding cache", slog.String("component", "engine"), slog.String("backend", cacheConfig.Backend), slog.String("model", model))
+	return &CachedEngine{engine: engine, model: model, store: store}, nil
+}
+
+// cacheKey addresses an embedding by its model and its text, the text is normalised so that whitespace and
+// unicode composition differences do not cause misses
+func cacheKey(model string, text string) string {
+	normalised := strings.Join(strings.Fields(norm.NFC.String(text)), " ")
+	sum := sha256.Sum256([]byte(model + "\x00" + normalised))
+	return hex.EncodeToString(sum[:])
+}
+
+func (ce *CachedEngine) Embed(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := ce.EmbedBatch(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+func (ce *CachedEngine) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	keys := make([]string, len(texts))
+	for i, text := range texts {
+		keys[i] = cacheKey(ce.model, text)
+	}
+	cached, err := ce.store.get(ctx, keys)
+	if err != nil {
+		// the cache is only an optimisation, so a broken cache degrades to embedding everything
+		logger.Warn("could not read the embedding cache", slog.String("component", "engine"), slog.Any("error", err))
+		cached = make(map[string][]float32)
+	}
+
+	embeddings := make([][]float32, len(texts))
+	missing := make([]string, 0)
+	missingIndexes := make(map[string][]int)
+	for i, key := range keys {
+		if embedding, ok := cached[key]; ok {
+			embeddings[i] = embedding
+			continue
+		}
+		if _, ok := missingIndexes[key]; !ok {
+			missing = append(missing, texts[i])
+		}
+		missingIndexes[key] = append(missingIndexes[key], i)
+	}
+	hits := uint64(len(texts) - len(missing))
+	ce.hits.Add(hits)
+	ce.misses.Add(uint64(len(missing)))
+
+	if len(missing) > 0 {
+		computed, err := ce.engine.EmbedBatch(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		entries := make(map[string][]float32, len(missing))
+		for i, text := range missing {
+			key := cacheKey(ce.model, text)
+			for _, index := range missingIndexes[key] {
+				embeddings[index] = computed[i]
+			}
+			if len(computed[i]) > 0 {
+				entries[key] = computed[i]
+			}
+		}
+		if err := ce.store.put(ctx, entries); err != nil {
+			logger.Warn("could not write the embedding cache", slog.String("component", "engine"), slog.Any("error", err))
+		}
+	}
+
+	totalHits, totalMisses := ce.Stats()
+	logger.Info("embedded batch", slog.String("component", "engine"), slog.Uint64("hits", hits), slog.Int("misses", len(missing)), slog.Uint64("totalHits", totalHits), slog.Uint64("totalMisses", totalMisses))
+	return embeddings, nil
+}
+
+func (ce *CachedEngine) Dimension(ctx context.Context) (int, error) {
+	return ce.engine.Dimension(ctx)
+}
+
+// Stats returns the number of cache hits and misses since the engine was created
+func (ce *CachedEngine) Stats() (uint64, uint64) {
+	return ce.hits.Load(), ce.misses.Load()
+}
+
+func encodeEmbedding(embedding []float32) []byte {
+	encoded := make([]byte, 4*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint32(encoded[4*i:], math.Float32bits(value))
+	}
+	return encoded
+}
+
+func decodeEmbedding(encoded []byte) ([]float32, error) {
+	if len(encoded)%4 != 0 {
+		return nil, fmt.Errorf("embedding of %d bytes is not a float32 vector", len(encoded))
+	}
+	embedding := make([]float32, len(encoded)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(encoded[4*i:]))
+	}
+	return embedding, nil
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// modelMarker is the file recording the model the cache was last opened with, which the purge compares against
+const modelMarker = "model"
+
+// fileCacheStore keeps every embedding in its own file, sharded by the first byte of the key
+type fileCacheStore struct {
+	path string
+}
+
+func newFileCacheStore(ctx context.Context, path string, model string, purge bool) (*fileCacheStore, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if path == "" {
+		return nil, fmt.Errorf("file cache requires a path")
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+
+	// the marker is recorded on every open, so that purging enabled later compares against the model last used
+	markerPath := filepath.Join(path, modelMarker)
+	previous, err := os.ReadFile(markerPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if purge && err == nil && strings.TrimSpace(string(previous)) != model {
+		logger.Warn("embedding model changed, purging the cache", slog.String("component", "engine"), slog.String("previous", strings.TrimSpace(string(previous))), slog.String("model", model))
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				if err := os.RemoveAll(filepath.Join(path, entry.Name())); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err := os.WriteFile(markerPath, []byte(model+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	return &fileCacheStore{path: path}, nil
+}
+
+func (s *fileCacheStore) entryPath(key string) string {
+	return filepath.Join(s.path, key[:2], key)
+}
+
+func (s *fileCacheStore) get(ctx context.Context, keys []string) (map[string][]float32, error) {
+	entries := make(map[string][]float32)
+	for _, key := range keys {
+		encoded, err := os.ReadFile(s.entryPath(key))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		embedding, err := decodeEmbedding(encoded)
+		if err != nil {
+			// a truncated entry is recomputed and overwritten
+			continue
+		}
+		entries[key] = embedding
+	}
+	return entries, nil
+}
+
+func (s *fileCacheStore) put(ctx context.Context, entries map[string][]float32) error {
+	for key, embedding := range entries {
+		path := s.entryPath(key)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(path), key+".tmp*")
+		if err != nil {
+			return err
+		}
+		_, err = tmp.Write(encodeEmbedding(embedding))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+	return nil
+}
+
+// embeddingCacheEntry is the row of the postgres cache
+type embeddingCacheEntry struct {
+	Key       string `gorm:"primaryKey;size:64"`
+	Model     string `gorm:"index;not null"`
+	Embedding []byte `gorm:"not null"`
+}
+
+type postgresCacheStore struct {
+	db    *gorm.DB
+	model string
+}
+
+func newPostgresCacheStore(ctx context.Context, postgresConfig config.PostgresConfig, model string, purge bool) (*postgresCacheStore, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		postgresConfig.Host, postgresConfig.Port, postgresConfig.Username, postgresConfig.Password, postgresConfig.Name)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.WithContext(ctx).AutoMigrate(&embeddingCacheEntry{}); err != nil {
+		return nil, err
+	}
+
+	if purge {
+		purged := db.WithContext(ctx).Where("model <> ?", model).Delete(&embeddingCacheEntry{})
+		if purged.Error != nil {
+			return nil, purged.Error
+		}
+		if purged.RowsAffected > 0 {
+			logger.Info("purged the entries of other models from the cache", slog.String("component", "engine"), slog.String("model", model), slog.Int64("purged", purged.RowsAffected))
+		}
+	}
+	return &postgresCacheStore{db: db, model: model}, nil
+}
+
+func (s *postgresCacheStore) get(ctx context.Context, keys []string) (map[string][]float32, error) {
+	var rows []embeddingCacheEntry
+	if err := s.db.WithContext(ctx).Where("key IN ?", keys).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	entries := make(map[string][]float32, len(rows))
+	for _, row := range rows {
+		embedding, err := decodeEmbedding(row.Embedding)
+		if err != nil {
+			continue
+		}
+		entries[row.Key] = embedding
+	}
+	return entries, nil
+}
+
+func (s *postgresCacheStore) put(ctx context.Context, entries map[string][]float32) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	rows := make([]embeddingCacheEntry, 0, len(entries))
+	for key, embedding := range entries {
+		rows = append(rows, embeddingCacheEntry{Key: key, Model: s.model, Embedding: encodeEmbedding(embedding)})
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
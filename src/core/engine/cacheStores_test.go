@@ -0,0 +1,35 @@
+package engine
+
+import "testing"
+
+func TestFileCacheStorePurgesAfterModelChange(t *testing.T) {
+	ctx := testContext()
+	path := t.TempDir()
+	key := "ab0123456789"
+
+	store, err := newFileCacheStore(ctx, path, "model-a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.put(ctx, map[string][]float32{key: {1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// opening without purging keeps the entries but still records the model
+	store, err = newFileCacheStore(ctx, path, "model-a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := store.get(ctx, []string{key}); err != nil || len(entries) != 1 {
+		t.Fatalf("reopened cache has %v, %v", entries, err)
+	}
+
+	// enabling purging with another model drops the entries of the model recorded earlier
+	store, err = newFileCacheStore(ctx, path, "model-b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := store.get(ctx, []string{key}); err != nil || len(entries) != 0 {
+		t.Errorf("cache of another model kept %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// dimensionProbe is the text embedded to find out the size of the embeddings of a model
+const dimensionProbe = "dimension probe"
+
+// probedDimension remembers the embedding size of an engine once a probe succeeded
+type probedDimension struct {
+	mutex     sync.Mutex
+	dimension int
+}
+
+func (pd *probedDimension) get(ctx context.Context, engine Engine) (int, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	if pd.dimension > 0 {
+		return pd.dimension, nil
+	}
+
+	embedding, err := engine.Embed(ctx, dimensionProbe)
+	if err != nil {
+		logger.Error("could not probe the embedding dimension", slog.String("component", "engine"), slog.Any("error", err))
+		return 0, err
+	}
+	if len(embedding) == 0 {
+		logger.Error("probe returned an empty embedding", slog.String("component", "engine"))
+		return 0, fmt.Errorf("engine returned an empty embedding while probing its dimension")
+	}
+	pd.dimension = len(embedding)
+	logger.Info("probed the embedding dimension", slog.String("component", "engine"), slog.Int("dimension", pd.dimension))
+	return pd.dimension, nil
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"io"
 	"io/ioutil"
 	"log/slog"
 	"net/http"
@@ -13,12 +14,19 @@ import (
 
 type Engine interface {
 	Embed(ctx context.Context, text string) ([]float32, error)
+	// EmbedBatch embeds all the texts, returning the embeddings in the same order
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+	// Dimension is the size of the embeddings, engines that cannot know it up front probe the model once
+	Dimension(ctx context.Context) (int, error)
 }
 
 func NewEngine(ctx context.Context, engineConfig config.RawEngine) (Engine, error) {
 	logger := ctx.Value("logger").(*slog.Logger)
 
 	logger.Info("creating new engine", slog.String("component", "engine"))
+	var engine Engine
+	// model identifies the embeddings of the engine, so that cached embeddings of another model are never reused
+	var model string
 	switch engineConfig.Type {
 	case "ollama":
 		ollamaConfig, ok := engineConfig.Value.(config.OllamaConfig)
@@ -30,21 +38,87 @@ func NewEngine(ctx context.Context, engineConfig config.RawEngine) (Engine, erro
 		if err != nil {
 			return nil, err
 		}
-		return ollamaConnector, nil
+		engine, model = ollamaConnector, "ollama/"+ollamaConnector.Model
+	case "openai":
+		openAIConfig, ok := engineConfig.Value.(config.OpenAIEmbeddingConfig)
+		if !ok {
+			logger.Error("unable to parse openai config", slog.String("component", "engine"), slog.String("type", engineConfig.Type))
+			return nil, fmt.Errorf("engine config is not an openai config")
+		}
+		openAIConnector, err := NewOpenAIConnector(ctx, openAIConfig)
+		if err != nil {
+			return nil, err
+		}
+		engine, model = openAIConnector, fmt.Sprintf("openai/%s/%d", openAIConnector.Model, openAIConnector.Dimensions)
+	case "hash":
+		hashConfig, ok := engineConfig.Value.(config.HashEngineConfig)
+		if !ok {
+			logger.Error("unable to parse hash config", slog.String("component", "engine"), slog.String("type", engineConfig.Type))
+			return nil, fmt.Errorf("engine config is not a hash config")
+		}
+		hashEngine := NewHashEngine(ctx, hashConfig)
+		engine, model = hashEngine, fmt.Sprintf("hash/%d/%d", hashEngine.Dimensions, hashEngine.NGrams)
 	default:
 		logger.Error("unknown engine", slog.String("component", "engine"), slog.String("type", engineConfig.Type))
 		return nil, fmt.Errorf("engine type %s is not supported", engineConfig.Type)
 	}
+
+	if engineConfig.Cache.Backend == "" {
+		return engine, nil
+	}
+	return NewCachedEngine(ctx, engine, model, engineConfig.Cache)
 }
 
 type OllamaConnector struct {
-	Model    string
-	Endpoint string
+	Model       string
+	Endpoint    string
+	BatchSize   int
+	Concurrency int
+	dimension   probedDimension
+	client      *http.Client
+	retrier     retrier
+	breaker     *circuitBreaker
 }
 
 func NewOllamaConnector(ctx context.Context, config config.OllamaConfig) (*OllamaConnector, error) {
-	connector := OllamaConnector{Model: config.Model, Endpoint: config.Endpoint}
-	err := connector.pullModel(ctx)
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	timeout, err := parseDuration(config.Timeout, defaultRequestTimeout)
+	if err != nil {
+		logger.Error("invalid ollama timeout", slog.String("component", "engine"), slog.String("timeout", config.Timeout))
+		return nil, err
+	}
+	backoff, err := parseDuration(config.Backoff, defaultBackoff)
+	if err != nil {
+		logger.Error("invalid ollama backoff", slog.String("component", "engine"), slog.String("backoff", config.Backoff))
+		return nil, err
+	}
+	cooldown, err := parseDuration(config.BreakerCooldown, defaultBreakerCooldown)
+	if err != nil {
+		logger.Error("invalid ollama breaker cooldown", slog.String("component", "engine"), slog.String("breakerCooldown", config.BreakerCooldown))
+		return nil, err
+	}
+	retries := config.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+	threshold := config.BreakerThreshold
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+
+	connector := OllamaConnector{
+		Model:       config.Model,
+		Endpoint:    config.Endpoint,
+		BatchSize:   config.BatchSize,
+		Concurrency: config.Concurrency,
+		client:      &http.Client{Timeout: timeout},
+		retrier:     retrier{retries: retries, backoff: backoff},
+		breaker:     &circuitBreaker{threshold: threshold, cooldown: cooldown},
+	}
+	err = connector.pullModel(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -96,8 +170,6 @@ func (oc *OllamaConnector) pullModel(ctx context.Context) error {
 }
 
 func (oc *OllamaConnector) Embed(ctx context.Context, text string) ([]float32, error) {
-	logger := ctx.Value("logger").(*slog.Logger)
-
 	type embedRequest struct {
 		Model  string `json:"model"`
 		Prompt string `json:"prompt"`
@@ -106,26 +178,82 @@ func (oc *OllamaConnector) Embed(ctx context.Context, text string) ([]float32, e
 		Embedding []float32 `json:"embedding"`
 	}
 
-	body, _ := json.Marshal(embedRequest{
-		Model:  oc.Model,
-		Prompt: text,
-	})
-
-	url := oc.Endpoint + "/api/embeddings"
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	var result embedResponse
+	err := oc.post(ctx, "/api/embeddings", embedRequest{Model: oc.Model, Prompt: text}, &result)
 	if err != nil {
-		logger.Error("embedding request failed", slog.String("model", oc.Model), slog.String("component", "engine"), slog.Any("error", err))
 		return nil, err
 	}
-	defer resp.Body.Close()
-	respBody, _ := ioutil.ReadAll(resp.Body)
+	if len(result.Embedding) == 0 {
+		return nil, fmt.Errorf("model %s returned an empty embedding", oc.Model)
+	}
+	return result.Embedding, nil
+}
+
+func (oc *OllamaConnector) Dimension(ctx context.Context) (int, error) {
+	return oc.dimension.get(ctx, oc)
+}
+
+func (oc *OllamaConnector) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedInBatches(ctx, texts, oc.BatchSize, oc.Concurrency, oc.embedInputs)
+}
+
+// embedInputs embeds a batch in a single request to /api/embed, which unlike /api/embeddings accepts several inputs
+func (oc *OllamaConnector) embedInputs(ctx context.Context, inputs []string) ([][]float32, error) {
+	type embedRequest struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	type embedResponse struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
 
 	var result embedResponse
-	err = json.Unmarshal(respBody, &result)
+	err := oc.post(ctx, "/api/embed", embedRequest{Model: oc.Model, Input: inputs}, &result)
 	if err != nil {
-		logger.Error("could not unmarshal embedding response body", slog.String("model", oc.Model), slog.String("component", "engine"), slog.Any("error", err))
 		return nil, err
 	}
+	for i, embedding := range result.Embeddings {
+		if len(embedding) == 0 {
+			return nil, fmt.Errorf("model %s returned an empty embedding for input %d", oc.Model, i)
+		}
+	}
+	return result.Embeddings, nil
+}
 
-	return result.Embedding, nil
+// post sends a request to the server behind the circuit breaker, retrying transient failures, and decodes the response
+func (oc *OllamaConnector) post(ctx context.Context, path string, request any, response any) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("encoding request failed: %w", err)
+	}
+	err = oc.breaker.call(ctx, oc.retrier, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, oc.Endpoint+path, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("creating request failed: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := oc.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{status: resp.StatusCode, body: string(respBody)}
+		}
+		if err := json.Unmarshal(respBody, response); err != nil {
+			return fmt.Errorf("could not unmarshal response body: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("embedding request failed", slog.String("model", oc.Model), slog.String("path", path), slog.String("component", "engine"), slog.Any("error", err))
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"context"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultHashDimensions = 768
+	defaultHashNGrams     = 2
+)
+
+// HashEngine derives vectors from the text alone by feature hashing its word n-grams, so the same text always
+// gets the same vector and texts sharing words end up close. It needs no model server, which makes it suitable for
+// tests and dry runs but not for meaningful retrieval
+type HashEngine struct {
+	Dimensions int
+	NGrams     int
+}
+
+func NewHashEngine(ctx context.Context, config config.HashEngineConfig) *HashEngine {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	engine := &HashEngine{Dimensions: config.Dimensions, NGrams: config.NGrams}
+	if engine.Dimensions <= 0 {
+		engine.Dimensions = defaultHashDimensions
+	}
+	if engine.NGrams <= 0 {
+		engine.NGrams = defaultHashNGrams
+	}
+	logger.Info("created hash engine", slog.Int("dimensions", engine.Dimensions), slog.Int("ngrams", engine.NGrams), slog.String("component", "engine"))
+	return engine
+}
+
+func (he *HashEngine) Embed(ctx context.Context, text string) ([]float32, error) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	vector := make([]float64, he.Dimensions)
+	for n := 1; n <= he.NGrams; n++ {
+		for start := 0; start+n <= len(words); start++ {
+			hash := fnv.New64a()
+			_, _ = hash.Write([]byte(strings.Join(words[start:start+n], " ")))
+			sum := hash.Sum64()
+			// the sign bit comes from another part of the hash, so that collisions cancel out instead of piling up
+			sign := 1.0
+			if sum>>63 == 1 {
+				sign = -1.0
+			}
+			vector[sum%uint64(he.Dimensions)] += sign
+		}
+	}
+
+	norm := 0.0
+	for _, value := range vector {
+		norm += value * value
+	}
+	embedding := make([]float32, he.Dimensions)
+	if norm == 0 {
+		// an empty text still gets a valid unit vector rather than one the cosine distance cannot handle
+		embedding[0] = 1
+		return embedding, nil
+	}
+	norm = math.Sqrt(norm)
+	for i, value := range vector {
+		embedding[i] = float32(value / norm)
+	}
+	return embedding, nil
+}
+
+func (he *HashEngine) Dimension(ctx context.Context) (int, error) {
+	return he.Dimensions, nil
+}
+
+func (he *HashEngine) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		embedding, err := he.Embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, embedding)
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// dot is the cosine similarity of unit vectors
+func dot(a []float32, b []float32) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func TestHashEngine(t *testing.T) {
+	ctx := testContext()
+	engine := NewHashEngine(ctx, config.HashEngineConfig{})
+	if dimensions, _ := engine.Dimension(ctx); dimensions != defaultHashDimensions || engine.NGrams != defaultHashNGrams {
+		t.Errorf("defaults are %d dimensions and %d-grams", dimensions, engine.NGrams)
+	}
+
+	texts := []string{"Fix the page allocator", "fix THE page, allocator!", "a mail about the weather", "the page allocator leaks", ""}
+	embeddings, err := engine.EmbedBatch(ctx, texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewHashEngine(ctx, config.HashEngineConfig{}).EmbedBatch(ctx, texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(embeddings, again) {
+		t.Error("the same texts got different vectors")
+	}
+
+	for i, embedding := range embeddings {
+		if len(embedding) != defaultHashDimensions {
+			t.Fatalf("vector %d has %d dimensions", i, len(embedding))
+		}
+		if norm := math.Sqrt(dot(embedding, embedding)); math.Abs(norm-1) > 1e-5 {
+			t.Errorf("vector %d has norm %v, want 1", i, norm)
+		}
+	}
+	// case and punctuation are ignored, and texts sharing words are closer than unrelated ones
+	if similarity := dot(embeddings[0], embeddings[1]); math.Abs(similarity-1) > 1e-5 {
+		t.Errorf("texts differing in case and punctuation have similarity %v", similarity)
+	}
+	if related, unrelated := dot(embeddings[0], embeddings[3]), dot(embeddings[0], embeddings[2]); related <= unrelated {
+		t.Errorf("related texts have similarity %v, unrelated ones %v", related, unrelated)
+	}
+}
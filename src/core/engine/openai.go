@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/sashabaranov/go-openai"
+	"log/slog"
+)
+
+// OpenAIConnector embeds through the /embeddings endpoint of the OpenAI API or of any server compatible with it
+type OpenAIConnector struct {
+	Model       string
+	Dimensions  int
+	BatchSize   int
+	Concurrency int
+	client      *openai.Client
+	dimension   probedDimension
+}
+
+func NewOpenAIConnector(ctx context.Context, config config.OpenAIEmbeddingConfig) (*OpenAIConnector, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if config.Model == "" {
+		logger.Error("no embedding model configured", slog.String("component", "engine"))
+		return nil, fmt.Errorf("openai engine requires a model")
+	}
+	clientConfig := openai.DefaultConfig(config.APIKey)
+	if config.BaseURL != "" {
+		clientConfig.BaseURL = config.BaseURL
+	}
+	logger.Info("created openai engine", slog.String("model", config.Model), slog.String("endpoint", clientConfig.BaseURL), slog.String("component", "engine"))
+	return &OpenAIConnector{
+		Model:       config.Model,
+		Dimensions:  config.Dimensions,
+		BatchSize:   config.BatchSize,
+		Concurrency: config.Concurrency,
+		client:      openai.NewClientWithConfig(clientConfig),
+	}, nil
+}
+
+func (oc *OpenAIConnector) Embed(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := oc.embedInputs(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// Dimension probes the model even when dimensions are configured, as not every model honours the setting
+func (oc *OpenAIConnector) Dimension(ctx context.Context) (int, error) {
+	return oc.dimension.get(ctx, oc)
+}
+
+func (oc *OpenAIConnector) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedInBatches(ctx, texts, oc.BatchSize, oc.Concurrency, oc.embedInputs)
+}
+
+func (oc *OpenAIConnector) embedInputs(ctx context.Context, inputs []string) ([][]float32, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	response, err := oc.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input:      inputs,
+		Model:      openai.EmbeddingModel(oc.Model),
+		Dimensions: oc.Dimensions,
+	})
+	if err != nil {
+		logger.Error("embedding request failed", slog.String("model", oc.Model), slog.String("component", "engine"), slog.Any("error", err))
+		return nil, err
+	}
+	if len(response.Data) != len(inputs) {
+		logger.Error("embedding response does not match the request", slog.String("model", oc.Model), slog.String("component", "engine"), slog.Int("inputs", len(inputs)), slog.Int("embeddings", len(response.Data)))
+		return nil, fmt.Errorf("expected %d embeddings from model %s, got %d", len(inputs), oc.Model, len(response.Data))
+	}
+
+	// the embeddings carry the index of their input, which servers are not required to keep in order
+	embeddings := make([][]float32, len(inputs))
+	for _, embedding := range response.Data {
+		if embedding.Index < 0 || embedding.Index >= len(inputs) {
+			return nil, fmt.Errorf("embedding index %d is out of range", embedding.Index)
+		}
+		embeddings[embedding.Index] = embedding.Embedding
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestTimeout   = 60 * time.Second
+	defaultRetries          = 3
+	defaultBackoff          = 500 * time.Millisecond
+	maxBackoff              = 30 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// statusError is a response of the model server with an unexpected status code
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.status, e.body)
+}
+
+// transient reports whether retrying the request may succeed: network failures, timeouts, overload and server errors
+func transient(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.status == http.StatusTooManyRequests || status.status >= http.StatusInternalServerError
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retrier runs a request again on transient failures, backing off exponentially with full jitter
+type retrier struct {
+	retries int
+	backoff time.Duration
+}
+
+func (r retrier) do(ctx context.Context, request func(ctx context.Context) error) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = request(ctx)
+		if err == nil || !transient(err) || attempt >= r.retries || ctx.Err() != nil {
+			return err
+		}
+
+		backoff := r.backoff << attempt
+		if backoff <= 0 || backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+		logger.Warn("retrying failed request", slog.String("component", "engine"), slog.Int("attempt", attempt+1), slog.Duration("backoff", wait), slog.Any("error", err))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// circuitBreaker stops calling an unhealthy server. After threshold consecutive failures it opens, and every call
+// waits until the cooldown has passed instead of failing, so ingestion pauses rather than losing data. Once the
+// cooldown has passed the breaker is half-open, a single call probes the server without retries while the others
+// keep waiting for its outcome. A failed probe opens the breaker again and any other outcome closes it
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	// probeDone is closed once the probe of the half-open breaker returns, it is nil while no probe is running
+	probeDone chan struct{}
+}
+
+// wait blocks while the breaker is open or another call probes the server, and reports whether the caller is the probe
+func (cb *circuitBreaker) wait(ctx context.Context) (bool, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	for {
+		cb.mutex.Lock()
+		if cb.failures < cb.threshold {
+			cb.mutex.Unlock()
+			return false, nil
+		}
+		remaining := time.Until(cb.openUntil)
+		if remaining <= 0 && cb.probeDone == nil {
+			cb.probeDone = make(chan struct{})
+			cb.mutex.Unlock()
+			logger.Info("circuit breaker is half-open, probing the server", slog.String("component", "engine"))
+			return true, nil
+		}
+		probeDone := cb.probeDone
+		cb.mutex.Unlock()
+
+		if remaining > 0 {
+			logger.Warn("circuit breaker is open, pausing", slog.String("component", "engine"), slog.Duration("remaining", remaining))
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-time.After(remaining):
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-probeDone:
+		}
+	}
+}
+
+func (cb *circuitBreaker) record(ctx context.Context, err error, probe bool) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if probe {
+		close(cb.probeDone)
+		cb.probeDone = nil
+		// a cancelled probe says nothing about the server, the next call probes it instead
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+	}
+	// only an unhealthy server opens the breaker, a request it rejects says nothing about its health
+	if err == nil || !transient(err) {
+		if cb.failures >= cb.threshold {
+			logger.Info("circuit breaker closed", slog.String("component", "engine"))
+		}
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	if probe || cb.failures == cb.threshold {
+		cb.openUntil = time.Now().Add(cb.cooldown)
+		logger.Error("circuit breaker opened", slog.String("component", "engine"), slog.Int("failures", cb.failures), slog.Duration("cooldown", cb.cooldown), slog.Any("error", err))
+	}
+}
+
+// call runs the request behind the breaker, retrying transient failures unless it probes the server
+func (cb *circuitBreaker) call(ctx context.Context, r retrier, request func(ctx context.Context) error) error {
+	probe, err := cb.wait(ctx)
+	if err != nil {
+		return err
+	}
+	if probe {
+		r = retrier{}
+	}
+	err = r.do(ctx, request)
+	cb.record(ctx, err, probe)
+	return err
+}
+
+// parseDuration parses an optional duration from the config
+func parseDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("duration %s has to be positive", value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testContext() context.Context {
+	return context.WithValue(context.Background(), "logger", slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestCircuitBreakerProbesOnce(t *testing.T) {
+	ctx := testContext()
+	cb := &circuitBreaker{threshold: 2, cooldown: 20 * time.Millisecond}
+	unavailable := &statusError{status: http.StatusServiceUnavailable}
+	for i := 0; i < 2; i++ {
+		_ = cb.call(ctx, retrier{}, func(ctx context.Context) error { return unavailable })
+	}
+
+	// every caller waits out the cooldown, then only the probe reaches the server until it has answered
+	release := make(chan struct{})
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := cb.call(ctx, retrier{retries: 3}, func(ctx context.Context) error {
+				if calls.Add(1) == 1 {
+					<-release
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := calls.Load(); got != 1 {
+		t.Errorf("%d calls reached the server while probing, want 1", got)
+	}
+	close(release)
+	wg.Wait()
+	if got := calls.Load(); got != 8 {
+		t.Errorf("%d calls reached the server after closing, want 8", got)
+	}
+}
+
+func TestCircuitBreakerReopensOnFailedProbe(t *testing.T) {
+	ctx := testContext()
+	cb := &circuitBreaker{threshold: 1, cooldown: 10 * time.Millisecond}
+	unavailable := &statusError{status: http.StatusServiceUnavailable}
+	_ = cb.call(ctx, retrier{}, func(ctx context.Context) error { return unavailable })
+
+	// the probe is not retried, so a single failure opens the breaker again
+	var calls atomic.Int32
+	_ = cb.call(ctx, retrier{retries: 3, backoff: time.Millisecond}, func(ctx context.Context) error {
+		calls.Add(1)
+		return unavailable
+	})
+	if got := calls.Load(); got != 1 {
+		t.Errorf("probe was attempted %d times, want 1", got)
+	}
+	cb.mutex.Lock()
+	open := time.Until(cb.openUntil) > 0
+	cb.mutex.Unlock()
+	if !open {
+		t.Error("breaker is not open after a failed probe")
+	}
+
+	deadline, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := cb.call(deadline, retrier{}, func(ctx context.Context) error { return nil }); err == nil {
+		t.Error("call went through an open breaker")
+	}
+}
@@ -0,0 +1,561 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/engine"
+	"github.com/qdrant/go-client/qdrant"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// MemoryConnector keeps the points in the memory of the process and searches them by brute force. The filters and
+// the consumed flag behave as they do in qdrant, so the pipeline runs the same against it. The snapshot is what the
+// processes of the pipeline share: every read and write takes a lock on it and reloads it when another process has
+// written it since, and every write replaces it before the lock is released
+type MemoryConnector struct {
+	config    config.MemoryConfig
+	generator engine.Engine
+
+	mutex sync.Mutex
+	// points are kept in insertion order, which is the order ties and scrolls resolve in
+	points []storedPoint
+	index  map[string]int
+	// lockFile is the open lock of the snapshot while it is held, it holds the generation of the snapshot
+	lockFile *os.File
+	// generation is the generation of the snapshot the points were last loaded from or saved as, loaded tells whether
+	// they were loaded at all
+	generation uint64
+	loaded     bool
+}
+
+// memorySnapshot is the file format of a snapshot, the payloads are kept in their protobuf JSON encoding so that the
+// kinds of the values survive
+type memorySnapshot struct {
+	Points []memorySnapshotPoint `json:"points"`
+}
+
+type memorySnapshotPoint struct {
+	ID      string          `json:"id"`
+	Payload json.RawMessage `json:"payload"`
+	Vector  []float32       `json:"vector"`
+}
+
+func NewMemoryConnector(ctx context.Context, memoryConfig config.MemoryConfig, generator engine.Engine) (*MemoryConnector, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if memoryConfig.Distance != "" && memoryConfig.Distance != "cosine" && memoryConfig.Distance != "dot" {
+		logger.Error("unknown distance", slog.String("distance", memoryConfig.Distance), slog.String("component", "sink"))
+		return nil, fmt.Errorf("distance %s is not supported", memoryConfig.Distance)
+	}
+	connector := &MemoryConnector{config: memoryConfig, generator: generator, index: make(map[string]int)}
+	unlock, err := connector.lock(true)
+	if err != nil {
+		logger.Error("could not load the snapshot", slog.String("path", memoryConfig.Snapshot), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, err
+	}
+	defer unlock()
+
+	// only the writers embed, so only they need the points to match the engine
+	if generator != nil && len(connector.points) > 0 {
+		size, err := generator.Dimension(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the embedding dimension: %w", err)
+		}
+		existing := len(connector.points[0].embedding)
+		if existing != size {
+			if memoryConfig.OnDimensionMismatch != "recreate" {
+				logger.Error("snapshot holds vectors of another embedding dimension", slog.String("collection", memoryConfig.Collection), slog.Int("snapshotDimension", existing), slog.Int("engineDimension", size), slog.String("component", "sink"))
+				return nil, fmt.Errorf("snapshot %s holds vectors of size %d but the engine produces %d, set onDimensionMismatch to recreate to drop it", memoryConfig.Snapshot, existing, size)
+			}
+			logger.Warn("dropping the points for the new embedding dimension", slog.String("collection", memoryConfig.Collection), slog.Int("snapshotDimension", existing), slog.Int("engineDimension", size), slog.String("component", "sink"))
+			connector.points = nil
+			connector.index = make(map[string]int)
+			if err := connector.save(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	logger.Info("created memory sink", slog.String("collection", memoryConfig.Collection), slog.Int("points", len(connector.points)), slog.String("component", "sink"))
+	return connector, nil
+}
+
+// lock takes the mutex and a lock on the snapshot, shared for reads and exclusive for writes, and reloads the
+// snapshot if another process has replaced it since. The returned function releases both
+func (m *MemoryConnector) lock(exclusive bool) (func(), error) {
+	m.mutex.Lock()
+	if m.config.Snapshot == "" {
+		return m.mutex.Unlock, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(m.config.Snapshot), 0o755); err != nil {
+		m.mutex.Unlock()
+		return nil, fmt.Errorf("error creating snapshot directory: %w", err)
+	}
+	lockFile, err := os.OpenFile(m.config.Snapshot+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		m.mutex.Unlock()
+		return nil, fmt.Errorf("error opening snapshot lock: %w", err)
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), how); err != nil {
+		_ = lockFile.Close()
+		m.mutex.Unlock()
+		return nil, fmt.Errorf("error locking snapshot: %w", err)
+	}
+	m.lockFile = lockFile
+	unlock := func() {
+		// closing the file releases the lock
+		m.lockFile = nil
+		_ = lockFile.Close()
+		m.mutex.Unlock()
+	}
+	if err := m.refresh(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// snapshotGeneration reads the generation of the snapshot from its lock file. Every save increments it, so unlike the
+// snapshot, whose replaced files can get the inode of an earlier one back, it tells whether the snapshot changed
+func (m *MemoryConnector) snapshotGeneration() (uint64, error) {
+	content, err := io.ReadAll(io.NewSectionReader(m.lockFile, 0, 32))
+	if err != nil {
+		return 0, fmt.Errorf("error reading snapshot lock: %w", err)
+	}
+	if len(content) == 0 {
+		return 0, nil
+	}
+	generation, err := strconv.ParseUint(string(content), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error reading the generation of the snapshot: %w", err)
+	}
+	return generation, nil
+}
+
+// refresh loads the snapshot unless it is the generation the points were last loaded from or saved as. A missing
+// snapshot starts an empty collection. It is called with the locks held
+func (m *MemoryConnector) refresh() error {
+	generation, err := m.snapshotGeneration()
+	if err != nil {
+		return err
+	}
+	if m.loaded && generation == m.generation {
+		return nil
+	}
+	content, err := os.ReadFile(m.config.Snapshot)
+	if errors.Is(err, os.ErrNotExist) {
+		m.generation, m.loaded = generation, true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading snapshot: %w", err)
+	}
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return fmt.Errorf("error decoding snapshot: %w", err)
+	}
+	m.points = make([]storedPoint, 0, len(snapshot.Points))
+	m.index = make(map[string]int, len(snapshot.Points))
+	for _, point := range snapshot.Points {
+		var payload qdrant.Struct
+		if err := protojson.Unmarshal(point.Payload, &payload); err != nil {
+			return fmt.Errorf("error decoding the payload of %s: %w", point.ID, err)
+		}
+		m.put(storedPoint{id: point.ID, payload: payload.GetFields(), embedding: point.Vector})
+	}
+	m.generation, m.loaded = generation, true
+	return nil
+}
+
+// save writes the points to the snapshot, going through a temporary file so that a crash never leaves a partial
+// snapshot. The generation is incremented before the snapshot is replaced, a crash in between only makes the other
+// processes reload the same points. It is called with the exclusive locks held
+func (m *MemoryConnector) save() error {
+	if m.config.Snapshot == "" {
+		return nil
+	}
+	snapshot := memorySnapshot{Points: make([]memorySnapshotPoint, 0, len(m.points))}
+	for _, point := range m.points {
+		payload, err := protojson.Marshal(&qdrant.Struct{Fields: point.payload})
+		if err != nil {
+			return fmt.Errorf("error encoding the payload of %s: %w", point.id, err)
+		}
+		snapshot.Points = append(snapshot.Points, memorySnapshotPoint{ID: point.id, Payload: payload, Vector: point.embedding})
+	}
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("error encoding snapshot: %w", err)
+	}
+	tmp := m.config.Snapshot + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+	generation := m.generation + 1
+	if err := m.lockFile.Truncate(0); err != nil {
+		return fmt.Errorf("error writing the generation of the snapshot: %w", err)
+	}
+	if _, err := m.lockFile.WriteAt([]byte(strconv.FormatUint(generation, 10)), 0); err != nil {
+		return fmt.Errorf("error writing the generation of the snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, m.config.Snapshot); err != nil {
+		return fmt.Errorf("error replacing snapshot: %w", err)
+	}
+	m.generation = generation
+	return nil
+}
+
+// put inserts the point or replaces the point with the same id, it is called with the locks held
+func (m *MemoryConnector) put(point storedPoint) {
+	if i, ok := m.index[point.id]; ok {
+		m.points[i] = point
+		return
+	}
+	m.index[point.id] = len(m.points)
+	m.points = append(m.points, point)
+}
+
+func (m *MemoryConnector) Upsert(ctx context.Context, dataList []data.Data) ([]data.Metadata, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	plan, err := preparePoints(ctx, m.generator, m.config.Collection, dataList, m.storedDocuments)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.points) == 0 {
+		return plan.metadataList, nil
+	}
+
+	// the points are embedded without holding the lock, so the processor is not blocked by the engine
+	unlock, err := m.lock(true)
+	if err != nil {
+		logger.Error("could not load the snapshot", slog.String("path", m.config.Snapshot), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, err
+	}
+	defer unlock()
+	for _, point := range plan.points {
+		// a point that is already stored keeps its consumed flag, which may have been set since it was looked up
+		if i, ok := m.index[point.id]; ok {
+			point.payload["consumed"] = m.points[i].payload["consumed"]
+		}
+		m.put(point)
+	}
+	m.removeStaleChunks(plan.replaced)
+	if err := m.save(); err != nil {
+		logger.Error("could not save the snapshot", slog.String("path", m.config.Snapshot), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, err
+	}
+	logger.Info("successfully upserted the points", slog.String("collection", m.config.Collection), slog.String("count", strconv.Itoa(len(plan.points))))
+	return plan.metadataList, nil
+}
+
+// storedDocuments returns the content hash and the consumed flag of the documents that are already stored
+func (m *MemoryConnector) storedDocuments(ctx context.Context, ids []string) (map[string]storedDocument, error) {
+	unlock, err := m.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	documents := make(map[string]storedDocument)
+	for _, id := range ids {
+		if i, ok := m.index[id]; ok {
+			payload := m.points[i].payload
+			documents[id] = storedDocument{contentHash: payload["content_hash"].GetStringValue(), consumed: payload["consumed"].GetBoolValue()}
+		}
+	}
+	return documents, nil
+}
+
+// removeStaleChunks drops the chunks of the replaced documents that the new version of the document does not have,
+// it is called with the locks held
+func (m *MemoryConnector) removeStaleChunks(replaced map[string][]string) {
+	if len(replaced) == 0 {
+		return
+	}
+	keep := make(map[string]bool)
+	for _, ids := range replaced {
+		for _, id := range ids {
+			keep[id] = true
+		}
+	}
+	points := m.points[:0]
+	for _, point := range m.points {
+		if _, ok := replaced[point.payload["parent_id"].GetStringValue()]; ok && !keep[point.id] {
+			continue
+		}
+		points = append(points, point)
+	}
+	m.points = points
+	m.index = make(map[string]int, len(points))
+	for i, point := range points {
+		m.index[point.id] = i
+	}
+}
+
+// matches reports whether the payload holds the value under the key, strings, booleans and integers compare as
+// qdrant's keyword, boolean and integer matches do, which match a list holding the value among its elements
+func matches(payload map[string]*qdrant.Value, key string, value any) bool {
+	field, ok := payload[key]
+	if !ok {
+		return false
+	}
+	if list, ok := field.GetKind().(*qdrant.Value_ListValue); ok {
+		for _, element := range list.ListValue.GetValues() {
+			if matchesValue(element, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return matchesValue(field, value)
+}
+
+func matchesValue(field *qdrant.Value, value any) bool {
+	switch v := value.(type) {
+	case string:
+		s, ok := field.GetKind().(*qdrant.Value_StringValue)
+		return ok && s.StringValue == v
+	case bool:
+		b, ok := field.GetKind().(*qdrant.Value_BoolValue)
+		return ok && b.BoolValue == v
+	case int64:
+		i, ok := field.GetKind().(*qdrant.Value_IntegerValue)
+		return ok && i.IntegerValue == v
+	default:
+		return false
+	}
+}
+
+// satisfies reports whether the payload satisfies the filter of a validated query
+func satisfies(payload map[string]*qdrant.Value, filter Filter) bool {
+	for _, condition := range filter.Must {
+		if !holds(payload, condition) {
+			return false
+		}
+	}
+	for _, condition := range filter.MustNot {
+		if holds(payload, condition) {
+			return false
+		}
+	}
+	return true
+}
+
+func holds(payload map[string]*qdrant.Value, condition Condition) bool {
+	switch condition.Operator {
+	case OperatorEquals:
+		value, _ := scalar(condition.Value)
+		return matches(payload, condition.Field, value)
+	case OperatorIn:
+		for _, value := range condition.Values {
+			normalized, _ := scalar(value)
+			if matches(payload, condition.Field, normalized) {
+				return true
+			}
+		}
+		return false
+	case OperatorRange:
+		switch number := payload[condition.Field].GetKind().(type) {
+		case *qdrant.Value_DoubleValue:
+			return condition.Range.within(number.DoubleValue)
+		case *qdrant.Value_IntegerValue:
+			return condition.Range.within(float64(number.IntegerValue))
+		default:
+			return false
+		}
+	case OperatorText:
+		text, ok := payload[condition.Field].GetKind().(*qdrant.Value_StringValue)
+		if !ok {
+			return false
+		}
+		words := make(map[string]bool)
+		for _, word := range textWords(text.StringValue) {
+			words[word] = true
+		}
+		for _, word := range textWords(condition.Value.(string)) {
+			if !words[word] {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *MemoryConnector) similarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) {
+		return math.Inf(-1)
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if m.config.Distance == "dot" {
+		return dot
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+func (m *MemoryConnector) Fetch(ctx context.Context, query Query) (map[string]data.Data, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if err := query.validate(); err != nil {
+		logger.Error("invalid query", slog.String("component", "sink"), slog.Any("error", err))
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	id := query.ReferenceID
+
+	unlock, err := m.lock(false)
+	if err != nil {
+		logger.Error("could not load the snapshot", slog.String("path", m.config.Snapshot), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, err
+	}
+	defer unlock()
+
+	vector := query.Vector
+	if len(vector) == 0 {
+		for i := range m.points {
+			if matches(m.points[i].payload, "mail_id", id) && matches(m.points[i].payload, "consumed", false) {
+				vector = m.points[i].embedding
+				break
+			}
+		}
+		if len(vector) == 0 {
+			logger.Error("could not find reference point by payload id", slog.String("id", id), slog.String("component", "sink"))
+			return nil, fmt.Errorf("reference point with id %s not found or has no vectors: %w", id, ErrReferenceNotFound)
+		}
+	}
+
+	type scored struct {
+		point storedPoint
+		score float64
+	}
+	candidates := make([]scored, 0)
+	for _, point := range m.points {
+		if satisfies(point.payload, query.Filter) {
+			candidates = append(candidates, scored{point: point, score: m.similarity(vector, point.embedding)})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	candidates = candidates[min(query.Offset, len(candidates)):]
+	if len(candidates) > query.Limit {
+		candidates = candidates[:query.Limit]
+	}
+
+	// convert the points to []data.Data, collapsing the chunks back to the documents they belong to
+	hits := make([]storedPoint, 0, len(candidates))
+	for _, candidate := range candidates {
+		hits = append(hits, candidate.point)
+	}
+	results, missingParents := collapseChunks(ctx, hits)
+	for _, parentID := range missingParents {
+		if i, ok := m.index[parentID]; ok {
+			decodePoint(ctx, parentID, m.points[i].payload, results)
+		}
+	}
+	return results, nil
+}
+
+func (m *MemoryConnector) FetchThread(ctx context.Context, root string) ([]data.Data, []string, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	unlock, err := m.lock(false)
+	if err != nil {
+		logger.Error("could not load the snapshot", slog.String("path", m.config.Snapshot), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, nil, err
+	}
+	points := make([]storedPoint, 0)
+	for _, point := range m.points {
+		// a chunked mail is represented by its first chunk
+		if index, _ := data.IsChunk(point.payload); index > 0 {
+			continue
+		}
+		if matches(point.payload, "thread_root", root) {
+			points = append(points, point)
+		}
+	}
+	unlock()
+
+	// chronological order, with the position in the reply chain breaking ties between mails sent in the same second
+	sort.SliceStable(points, func(i, j int) bool {
+		di, dj := points[i].payload["date"].GetDoubleValue(), points[j].payload["date"].GetDoubleValue()
+		if di != dj {
+			return di < dj
+		}
+		return points[i].payload["thread_position"].GetIntegerValue() < points[j].payload["thread_position"].GetIntegerValue()
+	})
+
+	thread := make([]data.Data, 0, len(points))
+	ids := make([]string, 0, len(points))
+	for _, point := range points {
+		d, err := data.FromQdrantPayload(point.payload)
+		if err != nil {
+			logger.Warn("skipping point with an undecodable payload", slog.String("id", point.id), slog.String("component", "sink"), slog.Any("error", err))
+			continue
+		}
+		thread = append(thread, d)
+		ids = append(ids, point.id)
+	}
+	logger.Info("fetched thread", slog.String("root", root), slog.Int("count", len(thread)), slog.String("component", "sink"))
+	return thread, ids, nil
+}
+
+// MarkConsumed marks the points as consumed, along with all the chunks of the documents whose first chunk is among them
+func (m *MemoryConnector) MarkConsumed(ctx context.Context, ids []string) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if len(ids) == 0 {
+		return nil
+	}
+	consumed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		consumed[id] = true
+	}
+
+	unlock, err := m.lock(true)
+	if err != nil {
+		logger.Error("could not load the snapshot", slog.String("path", m.config.Snapshot), slog.String("component", "sink"), slog.Any("error", err))
+		return err
+	}
+	defer unlock()
+	for i, point := range m.points {
+		if consumed[point.id] || consumed[point.payload["parent_id"].GetStringValue()] {
+			// readers may still hold the payload after releasing the lock, so it is copied rather than updated
+			payload := maps.Clone(point.payload)
+			payload["consumed"] = &qdrant.Value{Kind: &qdrant.Value_BoolValue{BoolValue: true}}
+			m.points[i].payload = payload
+		}
+	}
+	if err := m.save(); err != nil {
+		logger.Error("failed to mark points as consumed", slog.Any("error", err), slog.String("collection", m.config.Collection), slog.String("component", "sink"))
+		return fmt.Errorf("failed to mark points as consumed: %w", err)
+	}
+	return nil
+}
+
+func (m *MemoryConnector) GetCollection(ctx context.Context) string {
+	return m.config.Collection
+}
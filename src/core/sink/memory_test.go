@@ -0,0 +1,169 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/engine"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testContext() context.Context {
+	return context.WithValue(context.Background(), "logger", slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func testMail(id string, body string) data.MailData {
+	return data.MailData{
+		Metadata:  data.MailMetadata{Id: id},
+		Sender:    "jane@example.org",
+		Date:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Data:      body,
+		Subject:   "subject of " + id,
+		MessageID: id + "@example.org",
+		Source:    "file",
+	}
+}
+
+// TestMemorySnapshotIsShared runs an ingestor and a processor as two connectors on one snapshot, the way the two
+// binaries share it
+func TestMemorySnapshotIsShared(t *testing.T) {
+	ctx := testContext()
+	memoryConfig := config.MemoryConfig{Collection: "mails", Snapshot: filepath.Join(t.TempDir(), "mails.json")}
+	hash := engine.NewHashEngine(ctx, config.HashEngineConfig{Dimensions: 64, NGrams: 1})
+	ingestor, err := NewMemoryConnector(ctx, memoryConfig, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor, err := NewMemoryConnector(ctx, memoryConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// points ingested after the processor started are visible to it
+	if _, err := ingestor.Upsert(ctx, []data.Data{testMail("a", "the page allocator"), testMail("b", "the slab allocator")}); err != nil {
+		t.Fatal(err)
+	}
+	query := Query{Collection: "mails", ReferenceID: "a", Filter: Filter{Must: []Condition{Equals("consumed", false)}}, Limit: 10}
+	results, err := processor.Fetch(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("processor fetched %d points, want 2", len(results))
+	}
+
+	// the consumed flags of the processor survive the next write of the ingestor, and its new points reach the
+	// processor
+	var consumed string
+	for id, d := range results {
+		if d.GetMetadata().String() == "b" {
+			consumed = id
+		}
+	}
+	if err := processor.MarkConsumed(ctx, []string{consumed}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ingestor.Upsert(ctx, []data.Data{testMail("c", "the buddy allocator")}); err != nil {
+		t.Fatal(err)
+	}
+	results, err = processor.Fetch(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := results[consumed]; ok || len(results) != 2 {
+		t.Errorf("processor fetched %d points after consuming one of them and ingesting another, want 2 without %s", len(results), consumed)
+	}
+
+	// a third process starting from the snapshot sees the same state
+	restarted, err := NewMemoryConnector(ctx, memoryConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = restarted.Fetch(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("restarted connector fetched %d points, want 2", len(results))
+	}
+}
+
+func TestMemoryFetchConsumedReference(t *testing.T) {
+	ctx := testContext()
+	hash := engine.NewHashEngine(ctx, config.HashEngineConfig{Dimensions: 64, NGrams: 1})
+	memory, err := NewMemoryConnector(ctx, config.MemoryConfig{Collection: "mails"}, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := memory.Upsert(ctx, []data.Data{testMail("a", "the page allocator")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.MarkConsumed(ctx, []string{pointID(testMail("a", "").Key(), 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a message enqueued again for a consumed mail has no reference left, which the processor drops it for
+	_, err = memory.Fetch(ctx, Query{Collection: "mails", ReferenceID: "a", Limit: 10})
+	if !errors.Is(err, ErrReferenceNotFound) {
+		t.Errorf("fetch for a consumed reference gave %v, want %v", err, ErrReferenceNotFound)
+	}
+}
+
+func TestMemoryFetchFilters(t *testing.T) {
+	ctx := testContext()
+	hash := engine.NewHashEngine(ctx, config.HashEngineConfig{Dimensions: 64, NGrams: 1})
+	memory, err := NewMemoryConnector(ctx, config.MemoryConfig{Collection: "mails"}, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := testMail("old", "an old mail about locking")
+	old.Date = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	old.List = "netdev"
+	recent := testMail("recent", "a recent mail about locking")
+	recent.List = "linux-mm"
+	recent.References = []string{"root@example.org"}
+	if _, err := memory.Upsert(ctx, []data.Data{old, recent}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"none", Filter{}, []string{"old", "recent"}},
+		{"since", Filter{Must: []Condition{Since("date", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))}}, []string{"recent"}},
+		{"in", Filter{Must: []Condition{In("list", "netdev", "bpf")}}, []string{"old"}},
+		{"list element", Filter{Must: []Condition{Equals("references", "root@example.org")}}, []string{"recent"}},
+		{"text ignores case", Filter{Must: []Condition{Text("subject", "SUBJECT recent")}}, []string{"recent"}},
+		{"text ignores punctuation", Filter{Must: []Condition{Text("subject", "of, RECENT!")}}, []string{"recent"}},
+		{"text matches whole words", Filter{Must: []Condition{Text("subject", "ject recent")}}, nil},
+		{"not", Filter{MustNot: []Condition{Equals("list", "netdev")}}, []string{"recent"}},
+		{"missing field", Filter{Must: []Condition{Equals("patch_version", 2)}}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := memory.Fetch(ctx, Query{Collection: "mails", ReferenceID: "recent", Filter: test.filter, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]bool)
+			for _, d := range results {
+				got[d.GetMetadata().String()] = true
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("fetched %v, want %v", got, test.want)
+			}
+			for _, id := range test.want {
+				if !got[id] {
+					t.Errorf("fetched %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
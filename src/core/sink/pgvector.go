@@ -0,0 +1,393 @@
+package sink
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/engine"
+	"github.com/qdrant/go-client/qdrant"
+	"google.golang.org/protobuf/encoding/protojson"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// pgVector is a float32 vector in the text format of the pgvector extension, e.g. [1,2.5,3]
+type pgVector []float32
+
+func (v pgVector) Value() (driver.Value, error) {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, value := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(value), 'f', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String(), nil
+}
+
+func (v *pgVector) Scan(src any) error {
+	var text string
+	switch value := src.(type) {
+	case string:
+		text = value
+	case []byte:
+		text = string(value)
+	default:
+		return fmt.Errorf("cannot scan %T into a vector", src)
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "["), "]")
+	if text == "" {
+		*v = pgVector{}
+		return nil
+	}
+	fields := strings.Split(text, ",")
+	vector := make(pgVector, len(fields))
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+		if err != nil {
+			return fmt.Errorf("invalid vector component %q: %w", field, err)
+		}
+		vector[i] = float32(value)
+	}
+	*v = vector
+	return nil
+}
+
+// pgVectorPoint is a row of the table, the fields the sink filters and sorts on are kept in their own columns
+type pgVectorPoint struct {
+	ID             string `gorm:"primaryKey;type:uuid"`
+	MailID         string `gorm:"not null"`
+	ThreadRoot     string `gorm:"not null"`
+	ThreadPosition int64  `gorm:"not null"`
+	ParentID       string `gorm:"not null"`
+	ChunkIndex     int64  `gorm:"not null"`
+	Date           float64
+	ContentHash    string `gorm:"not null;default:''"`
+	Consumed       bool   `gorm:"not null"`
+	// Payload is the qdrant payload in its protobuf JSON encoding, which keeps the kinds of the values the data
+	// decoders check
+	Payload   string   `gorm:"type:jsonb;not null"`
+	Embedding pgVector `gorm:"type:vector;not null"`
+}
+
+type PgVectorConnector struct {
+	config    config.PgVectorConfig
+	db        *gorm.DB
+	generator engine.Engine
+}
+
+func NewPgVectorConnector(ctx context.Context, pgVectorConfig config.PgVectorConfig, generator engine.Engine) (*PgVectorConnector, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	postgresConfig := pgVectorConfig.Postgres
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		postgresConfig.Host, postgresConfig.Port, postgresConfig.Username, postgresConfig.Password, postgresConfig.Name)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		logger.Error("failed to connect to postgres", slog.String("host", postgresConfig.Host), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, err
+	}
+	connector := &PgVectorConnector{config: pgVectorConfig, db: db, generator: generator}
+
+	// only the writers embed, so only they need the table to match the engine
+	if generator != nil {
+		if err := connector.ensureTable(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return connector, nil
+}
+
+// ensureTable creates the table for the embedding size of the engine, or verifies that an existing table was created
+// for that size. pgvector records the dimension of a vector column as its type modifier
+func (p *PgVectorConnector) ensureTable(ctx context.Context) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	size, err := p.generator.Dimension(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to find the embedding dimension: %w", err)
+	}
+	db := p.db.WithContext(ctx)
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+		logger.Error("failed to create the vector extension", slog.String("component", "sink"), slog.Any("error", err))
+		return fmt.Errorf("failed to create the vector extension: %w", err)
+	}
+
+	var existing int
+	err = db.Raw("SELECT atttypmod FROM pg_attribute WHERE attrelid = to_regclass(?) AND attname = 'embedding'", p.config.Collection).Scan(&existing).Error
+	if err != nil {
+		return fmt.Errorf("failed to check table: %w", err)
+	}
+	if existing > 0 && existing != size {
+		if p.config.OnDimensionMismatch != "recreate" {
+			logger.Error("table was created for another embedding dimension", slog.String("table", p.config.Collection), slog.Int("tableDimension", existing), slog.Int("engineDimension", size), slog.String("component", "sink"))
+			return fmt.Errorf("table %s holds vectors of size %d but the engine produces %d, re-embed into a new table or set onDimensionMismatch to recreate to drop it", p.config.Collection, existing, size)
+		}
+		logger.Warn("recreating table for the new embedding dimension", slog.String("table", p.config.Collection), slog.Int("tableDimension", existing), slog.Int("engineDimension", size), slog.String("component", "sink"))
+		if err := db.Migrator().DropTable(p.config.Collection); err != nil {
+			return fmt.Errorf("failed to drop table: %w", err)
+		}
+	}
+
+	logger.Info("migrating table", slog.String("table", p.config.Collection), slog.Int("dimension", size), slog.String("component", "sink"))
+	if err := db.Table(p.config.Collection).AutoMigrate(&pgVectorPoint{}); err != nil {
+		logger.Error("failed to migrate table", slog.String("table", p.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
+		return fmt.Errorf("failed to migrate table: %w", err)
+	}
+	// the index names are prefixed with the table, as postgres requires them to be unique across tables
+	table := quoteIdentifier(p.config.Collection)
+	statements := make([]string, 0, 5)
+	if existing != size {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN embedding TYPE vector(%d)", table, size))
+	}
+	statements = append(statements,
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (mail_id)", quoteIdentifier(p.config.Collection+"_mail_id"), table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (thread_root)", quoteIdentifier(p.config.Collection+"_thread_root"), table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (parent_id)", quoteIdentifier(p.config.Collection+"_parent_id"), table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING hnsw (embedding vector_cosine_ops) WHERE NOT consumed", quoteIdentifier(p.config.Collection+"_embedding"), table),
+	)
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			logger.Error("failed to prepare table", slog.String("table", p.config.Collection), slog.String("statement", statement), slog.String("component", "sink"), slog.Any("error", err))
+			return fmt.Errorf("failed to prepare table: %w", err)
+		}
+	}
+	return nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (p *PgVectorConnector) Upsert(ctx context.Context, dataList []data.Data) ([]data.Metadata, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	logger.Info("creating the rows", slog.String("table", p.config.Collection), slog.String("component", "sink"))
+	plan, err := preparePoints(ctx, p.generator, p.config.Collection, dataList, p.storedDocuments)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.points) == 0 {
+		return plan.metadataList, nil
+	}
+	rows := make([]pgVectorPoint, 0, len(plan.points))
+	for _, point := range plan.points {
+		// the consumed flag lives in its own column, so it is not duplicated into the payload
+		consumed := point.payload["consumed"].GetBoolValue()
+		delete(point.payload, "consumed")
+		payload, err := protojson.Marshal(&qdrant.Struct{Fields: point.payload})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode payload: %w", err)
+		}
+		chunkIndex, _ := data.IsChunk(point.payload)
+		rows = append(rows, pgVectorPoint{
+			ID:             point.id,
+			MailID:         point.payload["mail_id"].GetStringValue(),
+			ThreadRoot:     point.payload["thread_root"].GetStringValue(),
+			ThreadPosition: point.payload["thread_position"].GetIntegerValue(),
+			ParentID:       point.payload["parent_id"].GetStringValue(),
+			ChunkIndex:     int64(chunkIndex),
+			Date:           point.payload["date"].GetDoubleValue(),
+			ContentHash:    point.payload["content_hash"].GetStringValue(),
+			Consumed:       consumed,
+			Payload:        string(payload),
+			Embedding:      point.embedding,
+		})
+	}
+
+	// a row that is already stored keeps the consumed flag it has in the table, which the processor may have set
+	// since it was looked up
+	logger.Info("upserting the rows", slog.String("table", p.config.Collection), slog.String("component", "sink"))
+	err = p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Table(p.config.Collection).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"mail_id", "thread_root", "thread_position", "parent_id", "chunk_index", "date", "content_hash", "payload", "embedding"}),
+		}).Create(&rows).Error
+		if err != nil {
+			return err
+		}
+		// the chunks left over from a longer version of a replaced document
+		for docID, ids := range plan.replaced {
+			if err := tx.Table(p.config.Collection).Where("parent_id = ? AND id NOT IN ?", docID, ids).Delete(&pgVectorPoint{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("could not upsert the rows", slog.String("table", p.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, fmt.Errorf("failed to upsert rows: %w", err)
+	}
+	logger.Info("successfully upserted the rows", slog.String("table", p.config.Collection), slog.String("count", strconv.Itoa(len(rows))))
+	return plan.metadataList, nil
+}
+
+// storedDocuments reads the content hash and the consumed flag of the documents that are already stored
+func (p *PgVectorConnector) storedDocuments(ctx context.Context, ids []string) (map[string]storedDocument, error) {
+	documents := make(map[string]storedDocument)
+	if len(ids) == 0 {
+		return documents, nil
+	}
+	var rows []pgVectorPoint
+	err := p.db.WithContext(ctx).Table(p.config.Collection).Select("id", "content_hash", "consumed").Where("id IN ?", ids).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows: %w", err)
+	}
+	for _, row := range rows {
+		documents[row.ID] = storedDocument{contentHash: row.ContentHash, consumed: row.Consumed}
+	}
+	return documents, nil
+}
+
+// decodeRow returns the stored point of a row, with the consumed flag restored into the payload
+func decodeRow(row pgVectorPoint) (storedPoint, error) {
+	var payload qdrant.Struct
+	if err := protojson.Unmarshal([]byte(row.Payload), &payload); err != nil {
+		return storedPoint{}, err
+	}
+	fields := payload.GetFields()
+	if fields == nil {
+		fields = make(map[string]*qdrant.Value)
+	}
+	fields["consumed"] = &qdrant.Value{Kind: &qdrant.Value_BoolValue{BoolValue: row.Consumed}}
+	return storedPoint{id: row.ID, payload: fields, embedding: row.Embedding}, nil
+}
+
+func (p *PgVectorConnector) Fetch(ctx context.Context, query Query) (map[string]data.Data, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if err := query.validate(); err != nil {
+		logger.Error("invalid query", slog.String("component", "sink"), slog.Any("error", err))
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	collection := query.Collection
+	id := query.ReferenceID
+
+	// getting the reference row
+	vector := pgVector(query.Vector)
+	if len(vector) == 0 {
+		var reference []pgVectorPoint
+		err := p.db.WithContext(ctx).Table(collection).Where("mail_id = ? AND NOT consumed", id).Limit(1).Find(&reference).Error
+		if err != nil {
+			logger.Error("could not fetch reference row by mail id", slog.String("id", id), slog.Any("error", err))
+			return nil, fmt.Errorf("failed to fetch reference row: %w", err)
+		}
+		if len(reference) == 0 || len(reference[0].Embedding) == 0 {
+			logger.Error("could not find reference row by mail id", slog.String("id", id), slog.String("component", "sink"))
+			return nil, fmt.Errorf("reference row with id %s not found or has no vector: %w", id, ErrReferenceNotFound)
+		}
+		logger.Info("successfully fetched reference row", slog.String("id", id), slog.String("component", "sink"))
+		vector = reference[0].Embedding
+	}
+
+	// the closest rows satisfying the filter by cosine distance
+	search := p.db.WithContext(ctx).Table(collection)
+	if where, vars := pgVectorWhere(query.Filter); where != "" {
+		search = search.Where(where, vars...)
+	}
+	var rows []pgVectorPoint
+	err := search.
+		Clauses(clause.OrderBy{Expression: clause.Expr{SQL: "embedding <=> ?::vector", Vars: []any{vector}}}).
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Find(&rows).Error
+	if err != nil {
+		logger.Error("could not search for vectors", slog.String("table", collection), slog.String("component", "sink"), slog.Any("error", err), slog.String("id", id))
+		return nil, fmt.Errorf("failed to search for vectors: %w", err)
+	}
+
+	// convert the rows to []data.Data, collapsing the chunks back to the documents they belong to
+	hits := make([]storedPoint, 0, len(rows))
+	for _, row := range rows {
+		point, err := decodeRow(row)
+		if err != nil {
+			logger.Warn("skipping row with an undecodable payload", slog.String("id", row.ID), slog.String("component", "sink"), slog.Any("error", err))
+			continue
+		}
+		hits = append(hits, point)
+	}
+	results, missingParents := collapseChunks(ctx, hits)
+
+	if len(missingParents) > 0 {
+		var parents []pgVectorPoint
+		err = p.db.WithContext(ctx).Table(collection).Where("id IN ?", missingParents).Find(&parents).Error
+		if err != nil {
+			logger.Error("could not fetch the parents of the chunks", slog.String("table", collection), slog.String("component", "sink"), slog.Any("error", err))
+			return nil, fmt.Errorf("failed to fetch chunk parents: %w", err)
+		}
+		for _, row := range parents {
+			point, err := decodeRow(row)
+			if err != nil {
+				logger.Warn("skipping row with an undecodable payload", slog.String("id", row.ID), slog.String("component", "sink"), slog.Any("error", err))
+				continue
+			}
+			decodePoint(ctx, point.id, point.payload, results)
+		}
+	}
+
+	return results, nil
+}
+
+func (p *PgVectorConnector) FetchThread(ctx context.Context, root string) ([]data.Data, []string, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	// chronological order, with the position in the reply chain breaking ties between mails sent in the same second.
+	// A chunked mail is represented by its first chunk
+	var rows []pgVectorPoint
+	err := p.db.WithContext(ctx).Table(p.config.Collection).
+		Where("thread_root = ? AND chunk_index = 0", root).
+		Order("date, thread_position").
+		Find(&rows).Error
+	if err != nil {
+		logger.Error("could not fetch thread", slog.String("root", root), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, nil, fmt.Errorf("failed to fetch thread: %w", err)
+	}
+
+	thread := make([]data.Data, 0, len(rows))
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		point, err := decodeRow(row)
+		if err != nil {
+			logger.Warn("skipping row with an undecodable payload", slog.String("id", row.ID), slog.String("component", "sink"), slog.Any("error", err))
+			continue
+		}
+		d, err := data.FromQdrantPayload(point.payload)
+		if err != nil {
+			logger.Warn("skipping row with an undecodable payload", slog.String("id", row.ID), slog.String("component", "sink"), slog.Any("error", err))
+			continue
+		}
+		thread = append(thread, d)
+		ids = append(ids, row.ID)
+	}
+	logger.Info("fetched thread", slog.String("root", root), slog.Int("count", len(thread)), slog.String("component", "sink"))
+	return thread, ids, nil
+}
+
+// MarkConsumed marks the rows as consumed, along with all the chunks of the documents whose first chunk is among them
+func (p *PgVectorConnector) MarkConsumed(ctx context.Context, ids []string) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if len(ids) == 0 {
+		return nil
+	}
+	err := p.db.WithContext(ctx).Table(p.config.Collection).
+		Where("id IN ? OR parent_id IN ?", ids, ids).
+		Update("consumed", true).Error
+	if err != nil {
+		logger.Error("failed to mark rows as consumed", slog.Any("error", err), slog.String("table", p.config.Collection), slog.String("component", "sink"))
+		return fmt.Errorf("failed to mark rows as consumed: %w", err)
+	}
+	return nil
+}
+
+func (p *PgVectorConnector) GetCollection(ctx context.Context) string {
+	return p.config.Collection
+}
@@ -0,0 +1,135 @@
+package sink
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// pgVectorColumns are the fields kept in their own columns, which conditions use rather than the payload. The
+// consumed flag is only up to date in its column
+var pgVectorColumns = map[string]string{
+	"mail_id":         "mail_id",
+	"thread_root":     "thread_root",
+	"thread_position": "thread_position",
+	"parent_id":       "parent_id",
+	"chunk_index":     "chunk_index",
+	"date":            "date",
+	"content_hash":    "content_hash",
+	"consumed":        "consumed",
+}
+
+// pgPayloadField is the value of a payload field in the protobuf JSON encoding of the payload, its key is bound as a
+// parameter
+const pgPayloadField = "payload->'fields'->?"
+
+// pgVectorWhere translates the filter into a WHERE clause and its parameters, the clause is empty when the filter has
+// no conditions. A condition on a missing field is NULL, which is taken as not satisfied so that its negation keeps
+// the row as qdrant does. The conditions on consumed are left bare, which lets the planner use the partial index
+func pgVectorWhere(filter Filter) (string, []any) {
+	clauses := make([]string, 0, len(filter.Must)+len(filter.MustNot))
+	vars := make([]any, 0)
+	for _, condition := range filter.Must {
+		clause, conditionVars := pgVectorCondition(condition)
+		clauses = append(clauses, clause)
+		vars = append(vars, conditionVars...)
+	}
+	for _, condition := range filter.MustNot {
+		clause, conditionVars := pgVectorCondition(condition)
+		clauses = append(clauses, "NOT coalesce("+clause+", false)")
+		vars = append(vars, conditionVars...)
+	}
+	return strings.Join(clauses, " AND "), vars
+}
+
+// pgVectorCondition translates a validated condition
+func pgVectorCondition(condition Condition) (string, []any) {
+	column, isColumn := pgVectorColumns[condition.Field]
+	switch condition.Operator {
+	case OperatorEquals:
+		value, _ := scalar(condition.Value)
+		if isColumn {
+			return "(" + column + " = ?)", []any{value}
+		}
+		return pgPayloadEquals(condition.Field, value)
+	case OperatorIn:
+		values := make([]any, 0, len(condition.Values))
+		for _, value := range condition.Values {
+			normalized, _ := scalar(value)
+			values = append(values, normalized)
+		}
+		if isColumn {
+			return "(" + column + " IN ?)", []any{values}
+		}
+		clauses := make([]string, 0, len(values))
+		vars := make([]any, 0)
+		for _, value := range values {
+			clause, valueVars := pgPayloadEquals(condition.Field, value)
+			clauses = append(clauses, clause)
+			vars = append(vars, valueVars...)
+		}
+		return "(" + strings.Join(clauses, " OR ") + ")", vars
+	case OperatorRange:
+		number := "(" + column + ")"
+		fieldVars := make([]any, 0)
+		if !isColumn {
+			number = "(coalesce(" + pgPayloadField + "->>'doubleValue', " + pgPayloadField + "->>'integerValue'))::double precision"
+			fieldVars = []any{condition.Field, condition.Field}
+		}
+		clauses := make([]string, 0, 4)
+		vars := make([]any, 0)
+		bounds := []struct {
+			operator string
+			bound    *float64
+		}{{">", condition.Range.Gt}, {">=", condition.Range.Gte}, {"<", condition.Range.Lt}, {"<=", condition.Range.Lte}}
+		for _, b := range bounds {
+			if b.bound == nil {
+				continue
+			}
+			clauses = append(clauses, number+" "+b.operator+" ?")
+			vars = append(vars, fieldVars...)
+			vars = append(vars, *b.bound)
+		}
+		return "(" + strings.Join(clauses, " AND ") + ")", vars
+	case OperatorText:
+		text := column
+		fieldVars := make([]any, 0)
+		if !isColumn {
+			text = pgPayloadField + "->>'stringValue'"
+			fieldVars = []any{condition.Field}
+		}
+		// a word is bounded by the ends of the text or by characters that are neither letters nor digits, the words
+		// are made of letters and digits only and need no escaping in the pattern
+		clauses := make([]string, 0)
+		vars := make([]any, 0)
+		for _, word := range textWords(condition.Value.(string)) {
+			clauses = append(clauses, text+" ~* ?")
+			vars = append(vars, fieldVars...)
+			vars = append(vars, "(^|[^[:alnum:]])"+word+"($|[^[:alnum:]])")
+		}
+		return "(" + strings.Join(clauses, " AND ") + ")", vars
+	default:
+		return "false", nil
+	}
+}
+
+// pgPayloadEquals compares a payload field with the value, or looks the value up among the elements of a list
+func pgPayloadEquals(field string, value any) (string, []any) {
+	kind, text := "stringValue", ""
+	switch v := value.(type) {
+	case string:
+		text = v
+	case bool:
+		kind, text = "boolValue", strconv.FormatBool(v)
+	case int64:
+		// the protobuf JSON encoding writes 64-bit integers as strings
+		kind, text = "integerValue", strconv.FormatInt(v, 10)
+	}
+	element := map[string]any{kind: value}
+	if kind == "integerValue" {
+		element[kind] = text
+	}
+	encoded, _ := json.Marshal([]any{element})
+	return "(" + pgPayloadField + "->>'" + kind + "' = ? OR " + pgPayloadField + "->'listValue'->'values' @> ?::jsonb)",
+		[]any{field, text, field, string(encoded)}
+}
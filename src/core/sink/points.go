@@ -0,0 +1,219 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/engine"
+	"github.com/google/uuid"
+	"github.com/qdrant/go-client/qdrant"
+	"log/slog"
+	"strconv"
+)
+
+// storedPoint is a document or a chunk as it is written to and read from a sink
+type storedPoint struct {
+	id        string
+	payload   map[string]*qdrant.Value
+	text      string
+	embedding []float32
+}
+
+// sinkSchema are the indexed fields the sinks add to the payload of the data
+var sinkSchema = map[string]data.FieldType{
+	"consumed":     data.FieldBool,
+	"parent_id":    data.FieldKeyword,
+	"content_hash": data.FieldKeyword,
+}
+
+// pointNamespace is the namespace of the UUIDv5 point ids
+var pointNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/ChinmayaSharma-hue/caelus/points"))
+
+// pointID derives the id of a point from the key of its document, so that ingesting a document again overwrites its
+// points. The first chunk has the id of the document, which is also the parent id of the other chunks
+func pointID(key string, chunkIndex int) string {
+	if chunkIndex == 0 {
+		return uuid.NewSHA1(pointNamespace, []byte(key)).String()
+	}
+	return uuid.NewSHA1(pointNamespace, []byte(key+"\x00"+strconv.Itoa(chunkIndex))).String()
+}
+
+// contentHash is the hash of the whole text of a document, every point of the document carries it
+func contentHash(d data.Data) string {
+	if chunk, ok := d.(data.ChunkData); ok {
+		d = chunk.Parent
+	}
+	sum := sha256.Sum256([]byte(d.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// storedDocument is the state of a document that is already in the sink
+type storedDocument struct {
+	contentHash string
+	consumed    bool
+}
+
+// documentLookup returns the state of the stored documents among the ids, leaving out the ones not stored yet
+type documentLookup func(ctx context.Context, ids []string) (map[string]storedDocument, error)
+
+// upsertPlan is what a sink writes for a batch
+type upsertPlan struct {
+	points []storedPoint
+	// metadataList holds the documents to enqueue, once per document, including unchanged ones that are not written
+	metadataList []data.Metadata
+	// replaced maps the documents whose content changed to the ids of their new points, any other point of such a
+	// document is a chunk left over from a longer version and has to be removed
+	replaced map[string][]string
+}
+
+// preparePoints embeds the data that is new or changed since it was last ingested and assigns the point ids. A stored
+// document keeps its consumed flag, and every document that is not consumed yet is enqueued, whether it is written or
+// not
+func preparePoints(ctx context.Context, generator engine.Engine, collection string, dataList []data.Data, lookup documentLookup) (upsertPlan, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	type candidate struct {
+		d     data.Data
+		id    string
+		docID string
+		hash  string
+	}
+	candidates := make([]candidate, 0, len(dataList))
+	seen := make(map[string]bool, len(dataList))
+	docIDs := make([]string, 0, len(dataList))
+	for _, d := range dataList {
+		index, _ := chunkIndex(d)
+		c := candidate{d: d, id: pointID(d.Key(), index), docID: pointID(d.Key(), 0), hash: contentHash(d)}
+		// overlapping fetches can return the same document twice in a batch
+		if seen[c.id] {
+			continue
+		}
+		seen[c.id] = true
+		if index == 0 {
+			docIDs = append(docIDs, c.docID)
+		}
+		candidates = append(candidates, c)
+	}
+
+	stored, err := lookup(ctx, docIDs)
+	if err != nil {
+		logger.Error("could not look up the stored documents", slog.String("collection", collection), slog.String("component", "sink"), slog.Any("error", err))
+		return upsertPlan{}, err
+	}
+
+	plan := upsertPlan{
+		points:       make([]storedPoint, 0, len(candidates)),
+		metadataList: make([]data.Metadata, 0, len(candidates)),
+		replaced:     make(map[string][]string),
+	}
+	enqueued := make(map[string]bool)
+	pending := make([]candidate, 0, len(candidates))
+	texts := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if document, ok := stored[c.docID]; ok && document.contentHash == c.hash {
+			// an unchanged document is not written again, but it is enqueued again until it is consumed in case the
+			// enqueue after its first write failed, the buffer deduplicates the messages by document
+			if !document.consumed && !enqueued[c.docID] {
+				enqueued[c.docID] = true
+				plan.metadataList = append(plan.metadataList, c.d.GetMetadata())
+			}
+			continue
+		}
+		pending = append(pending, c)
+		texts = append(texts, c.d.String())
+	}
+	logger.Info("skipping unchanged documents", slog.String("collection", collection), slog.Int("unchanged", len(candidates)-len(pending)), slog.Int("pending", len(pending)), slog.String("component", "sink"))
+
+	if len(pending) == 0 {
+		return plan, nil
+	}
+	embeddings, err := generator.EmbedBatch(ctx, texts)
+	if err != nil {
+		return upsertPlan{}, err
+	}
+
+	for i, c := range pending {
+		embedding := embeddings[i]
+		// a missing vector fails the whole batch, so that the batch is retried instead of leaving a hole
+		if len(embedding) == 0 {
+			logger.Error("embedding is empty",
+				slog.String("collection", collection),
+				slog.String("id", c.d.GetMetadata().String()),
+				slog.String("component", "sink"))
+			return upsertPlan{}, fmt.Errorf("embedding of %s is empty", c.d.GetMetadata().String())
+		}
+
+		document, exists := stored[c.docID]
+		payload := c.d.QdrantPayload()
+		// adding a consumed flag for smarter fetch based on this filter, a stored document keeps its flag
+		payload["consumed"] = &qdrant.Value{Kind: &qdrant.Value_BoolValue{BoolValue: document.consumed}}
+		payload["content_hash"] = &qdrant.Value{Kind: &qdrant.Value_StringValue{StringValue: c.hash}}
+		if _, ok := c.d.(data.ChunkData); ok {
+			payload["parent_id"] = &qdrant.Value{Kind: &qdrant.Value_StringValue{StringValue: c.docID}}
+		}
+		if exists {
+			plan.replaced[c.docID] = append(plan.replaced[c.docID], c.id)
+		}
+		if !document.consumed && !enqueued[c.docID] {
+			enqueued[c.docID] = true
+			plan.metadataList = append(plan.metadataList, c.d.GetMetadata())
+		}
+		plan.points = append(plan.points, storedPoint{id: c.id, payload: payload, text: texts[i], embedding: embedding})
+	}
+	return plan, nil
+}
+
+func chunkIndex(d data.Data) (int, bool) {
+	if chunk, ok := d.(data.ChunkData); ok {
+		return chunk.Index, true
+	}
+	return 0, false
+}
+
+// collapseChunks decodes the search hits into documents keyed by point id, every chunk being collapsed into the
+// document it belongs to under the id of its first chunk. Only the first chunk carries the text of the document, so
+// the ids of the first chunks that were not among the hits are returned for the caller to fetch
+func collapseChunks(ctx context.Context, hits []storedPoint) (map[string]data.Data, []string) {
+	results := make(map[string]data.Data)
+	missingParents := make([]string, 0)
+	for _, point := range hits {
+		id := point.id
+		index, chunked := data.IsChunk(point.payload)
+		if chunked {
+			id = point.payload["parent_id"].GetStringValue()
+			if _, ok := results[id]; ok {
+				continue
+			}
+			if index > 0 {
+				missingParents = append(missingParents, id)
+				continue
+			}
+		}
+		decodePoint(ctx, id, point.payload, results)
+	}
+
+	missing := make([]string, 0, len(missingParents))
+	seen := make(map[string]bool, len(missingParents))
+	for _, id := range missingParents {
+		if _, ok := results[id]; !ok && !seen[id] {
+			seen[id] = true
+			missing = append(missing, id)
+		}
+	}
+	return results, missing
+}
+
+// decodePoint adds the document stored in the payload to the results, a single broken payload should not keep the
+// rest of the context from being used so it is only logged
+func decodePoint(ctx context.Context, id string, payload map[string]*qdrant.Value, results map[string]data.Data) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	d, err := data.FromQdrantPayload(payload)
+	if err != nil {
+		logger.Warn("skipping point with an undecodable payload", slog.String("id", id), slog.String("component", "sink"), slog.Any("error", err))
+		return
+	}
+	results[id] = d
+}
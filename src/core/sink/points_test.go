@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"context"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/engine"
+	"testing"
+)
+
+func TestPreparePointsSkipsUnchangedDocuments(t *testing.T) {
+	ctx := testContext()
+	hash := engine.NewHashEngine(ctx, config.HashEngineConfig{Dimensions: 64, NGrams: 1})
+	fresh := testMail("fresh", "a new mail")
+	pending := testMail("pending", "an unchanged mail waiting in the queue")
+	consumed := testMail("consumed", "an unchanged mail already processed")
+	edited := testMail("edited", "the edited text of a consumed mail")
+	stored := map[string]storedDocument{
+		pointID(pending.Key(), 0):  {contentHash: contentHash(pending), consumed: false},
+		pointID(consumed.Key(), 0): {contentHash: contentHash(consumed), consumed: true},
+		pointID(edited.Key(), 0):   {contentHash: "the hash of the previous text", consumed: true},
+	}
+	lookup := func(ctx context.Context, ids []string) (map[string]storedDocument, error) {
+		found := make(map[string]storedDocument)
+		for _, id := range ids {
+			if document, ok := stored[id]; ok {
+				found[id] = document
+			}
+		}
+		return found, nil
+	}
+
+	plan, err := preparePoints(ctx, hash, "mails", []data.Data{fresh, pending, consumed, edited, fresh}, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(map[string]storedPoint)
+	for _, point := range plan.points {
+		written[point.id] = point
+	}
+	if len(written) != 2 || written[pointID(fresh.Key(), 0)].id == "" || written[pointID(edited.Key(), 0)].id == "" {
+		t.Errorf("wrote %d points, want the fresh and the edited mail", len(plan.points))
+	}
+	if !written[pointID(edited.Key(), 0)].payload["consumed"].GetBoolValue() {
+		t.Error("edited mail lost its consumed flag")
+	}
+
+	// the pending mail is enqueued again although it is not written, the consumed ones are not
+	enqueued := make(map[string]int)
+	for _, metadata := range plan.metadataList {
+		enqueued[metadata.String()]++
+	}
+	if len(enqueued) != 2 || enqueued["fresh"] != 1 || enqueued["pending"] != 1 {
+		t.Errorf("enqueued %v, want fresh and pending once", enqueued)
+	}
+
+	if ids := plan.replaced[pointID(edited.Key(), 0)]; len(ids) != 1 || len(plan.replaced) != 1 {
+		t.Errorf("replaced %v, want only the edited mail", plan.replaced)
+	}
+}
+
+func TestPreparePointsReplacesChunks(t *testing.T) {
+	ctx := testContext()
+	hash := engine.NewHashEngine(ctx, config.HashEngineConfig{Dimensions: 64, NGrams: 1})
+	mail := testMail("long", "a first part and a second part")
+	chunks := []data.Data{
+		data.ChunkData{Parent: mail, Index: 0, Total: 2, Text: "a first part"},
+		data.ChunkData{Parent: mail, Index: 1, Total: 2, Text: "and a second part"},
+	}
+	docID := pointID(mail.Key(), 0)
+	lookup := func(ctx context.Context, ids []string) (map[string]storedDocument, error) {
+		return map[string]storedDocument{docID: {contentHash: "the hash of a longer version"}}, nil
+	}
+
+	plan, err := preparePoints(ctx, hash, "mails", chunks, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.points) != 2 {
+		t.Fatalf("wrote %d points, want 2", len(plan.points))
+	}
+	if parent := plan.points[1].payload["parent_id"].GetStringValue(); parent != docID {
+		t.Errorf("second chunk has parent %q, want %q", parent, docID)
+	}
+	// the document is enqueued once for all of its chunks, and its new points are kept when the stale ones are removed
+	if len(plan.metadataList) != 1 {
+		t.Errorf("enqueued %d entries, want 1", len(plan.metadataList))
+	}
+	if ids := plan.replaced[docID]; len(ids) != 2 || ids[0] != docID || ids[1] != pointID(mail.Key(), 1) {
+		t.Errorf("replaced %v, want the ids of both chunks", ids)
+	}
+}
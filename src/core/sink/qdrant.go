@@ -2,25 +2,27 @@ package sink
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/engine"
-	"github.com/google/uuid"
 	"github.com/qdrant/go-client/qdrant"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"log/slog"
+	"sort"
 	"strconv"
 )
 
+const threadPageSize = 100
+
 type QdrantConnector struct {
 	config         config.QdrantConfig
 	grpcConnection *grpc.ClientConn
 	generator      engine.Engine
 	collection     string
+	sparse         bm25Encoder
 }
 
 func NewQdrantConnector(ctx context.Context, config config.QdrantConfig, generator engine.Engine) (*QdrantConnector, error) {
@@ -32,70 +34,129 @@ func NewQdrantConnector(ctx context.Context, config config.QdrantConfig, generat
 		logger.Error("failed to connect to qdrant", slog.String("url", url), slog.String("component", "sink"))
 		return nil, err
 	}
-	return &QdrantConnector{config: config, grpcConnection: conn, generator: generator, collection: config.Collection}, nil
+	if _, err := fusion(config.Hybrid); err != nil {
+		logger.Error("invalid hybrid search fusion", slog.String("fusion", config.Hybrid.Fusion), slog.String("component", "sink"))
+		return nil, err
+	}
+	connector := &QdrantConnector{
+		config:         config,
+		grpcConnection: conn,
+		generator:      generator,
+		collection:     config.Collection,
+		sparse:         newBM25Encoder(config.Hybrid.AverageLength),
+	}
+
+	// only the writers embed, so only they need the collection to match the engine
+	if generator != nil {
+		if err := connector.ensureCollection(ctx); err != nil {
+			return nil, err
+		}
+		if err := connector.ensurePayloadIndexes(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return connector, nil
 }
 
-func (q *QdrantConnector) Upsert(ctx context.Context, dataList []data.Data, size int) ([]data.Metadata, error) {
+// ensureCollection creates the collection for the embedding size of the engine, or verifies that an existing
+// collection was created for that size
+func (q *QdrantConnector) ensureCollection(ctx context.Context) error {
 	logger := ctx.Value("logger").(*slog.Logger)
 
-	// creating the collection
+	size, err := q.generator.Dimension(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to find the embedding dimension: %w", err)
+	}
+
 	collectionsClient := qdrant.NewCollectionsClient(q.grpcConnection)
-	_, err := collectionsClient.Get(ctx, &qdrant.GetCollectionInfoRequest{
+	info, err := collectionsClient.Get(ctx, &qdrant.GetCollectionInfoRequest{
 		CollectionName: q.config.Collection,
 	})
 	if err != nil {
-		// If not found, create it
 		st, ok := status.FromError(err)
-		if ok && st.Code() == codes.NotFound {
-			logger.Info("creating collection", slog.String("collection", q.config.Collection), slog.String("component", "sink"))
-			_, err = collectionsClient.Create(context.Background(), &qdrant.CreateCollection{
-				CollectionName: q.config.Collection,
-				VectorsConfig: &qdrant.VectorsConfig{
-					Config: &qdrant.VectorsConfig_Params{
-						Params: &qdrant.VectorParams{
-							Size:     uint64(size),
-							Distance: qdrant.Distance_Cosine,
-						},
-					},
-				},
-			})
-			if err != nil {
-				logger.Error("failed to create collection", slog.String("collection", q.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
-				return nil, fmt.Errorf("failed to create collection: %w", err)
-			}
-		} else {
+		if !ok || st.Code() != codes.NotFound {
 			logger.Error("failed to get collection", slog.String("collection", q.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
-			return nil, fmt.Errorf("failed to check collection: %w", err)
+			return fmt.Errorf("failed to check collection: %w", err)
 		}
+		return q.createCollection(ctx, size)
 	}
 
-	// creating the point
-	logger.Info("creating the points", slog.String("collection", q.config.Collection), slog.String("component", "sink"))
-	points := make([]*qdrant.PointStruct, 0, len(dataList))
-	metadataList := make([]data.Metadata, 0, len(dataList))
-	for _, d := range dataList {
-		embedding, err := q.generator.Embed(ctx, d.String())
-		if err != nil {
-			return nil, err
-		}
-		if len(embedding) == 0 {
-			logger.Warn("embedding is empty",
-				slog.String("collection", q.config.Collection),
-				slog.String("component", "sink"))
-			continue
+	params := info.GetResult().GetConfig().GetParams()
+	existing := params.GetVectorsConfig().GetParams().GetSize()
+	_, hasSparse := params.GetSparseVectorsConfig().GetMap()[sparseVectorName]
+	if existing == uint64(size) {
+		if q.config.Hybrid.Enabled && !hasSparse {
+			// sparse vectors cannot be added to an existing collection
+			logger.Error("collection has no sparse vectors for hybrid search", slog.String("collection", q.config.Collection), slog.String("component", "sink"))
+			return fmt.Errorf("collection %s was created without sparse vectors, re-embed it with the migrator to enable hybrid search", q.config.Collection)
 		}
+		logger.Info("collection matches the embedding dimension", slog.String("collection", q.config.Collection), slog.Int("dimension", size), slog.String("component", "sink"))
+		return nil
+	}
+	if q.config.OnDimensionMismatch != "recreate" {
+		logger.Error("collection was created for another embedding dimension", slog.String("collection", q.config.Collection), slog.Uint64("collectionDimension", existing), slog.Int("engineDimension", size), slog.String("component", "sink"))
+		return fmt.Errorf("collection %s holds vectors of size %d but the engine produces %d, re-embed it with the migrator or set onDimensionMismatch to recreate to drop it", q.config.Collection, existing, size)
+	}
 
-		uuidStr := uuid.New().String()
-		payload := d.QdrantPayload()
-		// adding a consumed flag for smarter fetch based on this filter
-		payload["consumed"] = &qdrant.Value{Kind: &qdrant.Value_BoolValue{BoolValue: false}}
-		point := &qdrant.PointStruct{
-			Id:      &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: uuidStr}},
-			Payload: payload,
-			Vectors: &qdrant.Vectors{VectorsOptions: &qdrant.Vectors_Vector{Vector: &qdrant.Vector{Data: embedding}}},
-		}
-		points = append(points, point)
-		metadataList = append(metadataList, d.GetMetadata())
+	logger.Warn("recreating collection for the new embedding dimension", slog.String("collection", q.config.Collection), slog.Uint64("collectionDimension", existing), slog.Int("engineDimension", size), slog.String("component", "sink"))
+	_, err = collectionsClient.Delete(ctx, &qdrant.DeleteCollection{CollectionName: q.config.Collection})
+	if err != nil {
+		logger.Error("failed to delete collection", slog.String("collection", q.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
+		return fmt.Errorf("failed to delete collection: %w", err)
+	}
+	return q.createCollection(ctx, size)
+}
+
+func (q *QdrantConnector) createCollection(ctx context.Context, size int) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	logger.Info("creating collection", slog.String("collection", q.config.Collection), slog.Int("dimension", size), slog.String("component", "sink"))
+	createCollection := &qdrant.CreateCollection{
+		CollectionName: q.config.Collection,
+		VectorsConfig: &qdrant.VectorsConfig{
+			Config: &qdrant.VectorsConfig_Params{
+				Params: &qdrant.VectorParams{
+					Size:     uint64(size),
+					Distance: qdrant.Distance_Cosine,
+				},
+			},
+		},
+	}
+	if q.config.Hybrid.Enabled {
+		// the inverse document frequency is kept up to date by qdrant, the points only carry the term frequencies
+		modifier := qdrant.Modifier_Idf
+		createCollection.SparseVectorsConfig = qdrant.NewSparseVectorsConfig(map[string]*qdrant.SparseVectorParams{
+			sparseVectorName: {Modifier: &modifier},
+		})
+	}
+	collectionsClient := qdrant.NewCollectionsClient(q.grpcConnection)
+	_, err := collectionsClient.Create(ctx, createCollection)
+	if err != nil {
+		logger.Error("failed to create collection", slog.String("collection", q.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
+		return fmt.Errorf("failed to create collection: %w", err)
+	}
+	return nil
+}
+
+func (q *QdrantConnector) Upsert(ctx context.Context, dataList []data.Data) ([]data.Metadata, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	// creating the point
+	logger.Info("creating the points", slog.String("collection", q.config.Collection), slog.String("component", "sink"))
+	plan, err := preparePoints(ctx, q.generator, q.config.Collection, dataList, q.storedDocuments)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.points) == 0 {
+		return plan.metadataList, nil
+	}
+	points := make([]*qdrant.PointStruct, 0, len(plan.points))
+	for _, p := range plan.points {
+		points = append(points, &qdrant.PointStruct{
+			Id:      &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: p.id}},
+			Payload: p.payload,
+			Vectors: q.pointVectors(p.text, p.embedding),
+		})
 	}
 
 	// upserting the points
@@ -109,128 +170,265 @@ func (q *QdrantConnector) Upsert(ctx context.Context, dataList []data.Data, size
 		logger.Error("could not upsert the points", slog.String("collection", q.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
 		return nil, fmt.Errorf("failed to upsert points: %w", err)
 	}
+	if err := q.removeStaleChunks(ctx, plan.replaced); err != nil {
+		return nil, err
+	}
 
 	logger.Info("successfully upserted the points", slog.String("collection", q.config.Collection), slog.String("count", strconv.Itoa(len(points))))
 
-	return metadataList, nil
+	return plan.metadataList, nil
 }
 
-func (q *QdrantConnector) Fetch(ctx context.Context, filters map[string]string) (map[string]data.Data, error) {
-	logger := ctx.Value("logger").(*slog.Logger)
-
-	// getting all the filters
-	collection, ok := filters["collection"]
-	if !ok {
-		logger.Error("no collection specified", slog.String("component", "sink"))
-		return nil, errors.New("no collection specified")
+// storedDocuments reads the content hash and the consumed flag of the documents that are already stored
+func (q *QdrantConnector) storedDocuments(ctx context.Context, ids []string) (map[string]storedDocument, error) {
+	documents := make(map[string]storedDocument)
+	if len(ids) == 0 {
+		return documents, nil
 	}
-	countStr, ok := filters["count"]
-	if !ok {
-		logger.Error("no count specified", slog.String("component", "sink"))
-		return nil, errors.New("missing 'count' in filters")
+	pointIDs := make([]*qdrant.PointId, 0, len(ids))
+	for _, id := range ids {
+		pointIDs = append(pointIDs, &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: id}})
 	}
-	count, err := strconv.Atoi(countStr)
+	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
+	getResp, err := pointsClient.Get(ctx, &qdrant.GetPoints{
+		CollectionName: q.config.Collection,
+		Ids:            pointIDs,
+		WithPayload: &qdrant.WithPayloadSelector{
+			SelectorOptions: &qdrant.WithPayloadSelector_Include{
+				Include: &qdrant.PayloadIncludeSelector{Fields: []string{"content_hash", "consumed"}},
+			},
+		},
+	})
 	if err != nil {
-		logger.Error("invalid 'count' in filters", slog.String("component", "sink"), slog.Any("error", err), slog.String("count", filters["count"]))
-		return nil, errors.New("invalid 'count' in filters")
+		return nil, fmt.Errorf("failed to get points: %w", err)
 	}
-	id, ok := filters["id"]
-	if !ok {
-		logger.Error("no id specified", slog.String("component", "sink"))
-		return nil, fmt.Errorf("missing 'id' in filters")
+	for _, point := range getResp.Result {
+		documents[point.Id.GetUuid()] = storedDocument{
+			contentHash: point.Payload["content_hash"].GetStringValue(),
+			consumed:    point.Payload["consumed"].GetBoolValue(),
+		}
 	}
+	return documents, nil
+}
+
+// removeStaleChunks deletes the chunks of the replaced documents that the new version of the document does not have
+func (q *QdrantConnector) removeStaleChunks(ctx context.Context, replaced map[string][]string) error {
+	logger := ctx.Value("logger").(*slog.Logger)
 
-	// getting the point based on ID
 	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
-	limit := uint32(1)
-	scrollResp, err := pointsClient.Scroll(ctx, &qdrant.ScrollPoints{
-		CollectionName: collection,
-		Filter: &qdrant.Filter{
-			Must: []*qdrant.Condition{
-				{
-					ConditionOneOf: &qdrant.Condition_Field{
-						Field: &qdrant.FieldCondition{
-							Key: "mail_id",
-							Match: &qdrant.Match{
-								MatchValue: &qdrant.Match_Text{Text: id},
+	for docID, ids := range replaced {
+		keep := make([]*qdrant.PointId, 0, len(ids))
+		for _, id := range ids {
+			keep = append(keep, &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: id}})
+		}
+		_, err := pointsClient.Delete(ctx, &qdrant.DeletePoints{
+			CollectionName: q.config.Collection,
+			Points: &qdrant.PointsSelector{
+				PointsSelectorOneOf: &qdrant.PointsSelector_Filter{Filter: &qdrant.Filter{
+					Must: []*qdrant.Condition{
+						{
+							ConditionOneOf: &qdrant.Condition_Field{
+								Field: &qdrant.FieldCondition{
+									Key: "parent_id",
+									Match: &qdrant.Match{
+										MatchValue: &qdrant.Match_Keyword{Keyword: docID},
+									},
+								},
 							},
 						},
 					},
-				},
-				{
-					ConditionOneOf: &qdrant.Condition_Field{
-						Field: &qdrant.FieldCondition{
-							Key: "consumed",
-							Match: &qdrant.Match{
-								MatchValue: &qdrant.Match_Boolean{Boolean: false},
-							},
+					MustNot: []*qdrant.Condition{
+						{
+							ConditionOneOf: &qdrant.Condition_HasId{HasId: &qdrant.HasIdCondition{HasId: keep}},
 						},
 					},
-				},
+				}},
 			},
-		},
-		Limit: &limit,
-		WithVectors: &qdrant.WithVectorsSelector{
-			SelectorOptions: &qdrant.WithVectorsSelector_Enable{Enable: true},
-		},
-	})
-	if err != nil {
-		logger.Error("could not fetch reference point by payload id", slog.String("id", id), slog.Any("error", err))
-		return nil, fmt.Errorf("failed to fetch reference point: %w", err)
+		})
+		if err != nil {
+			logger.Error("could not remove the stale chunks", slog.String("collection", q.config.Collection), slog.String("id", docID), slog.String("component", "sink"), slog.Any("error", err))
+			return fmt.Errorf("failed to remove stale chunks: %w", err)
+		}
 	}
-	if len(scrollResp.Result) == 0 || scrollResp.Result[0].Vectors == nil {
-		logger.Error("could not find reference point by payload id", slog.String("id", id), slog.Any("error", err), slog.String("component", "sink"))
-		return nil, fmt.Errorf("reference point with id %s not found or has no vectors", id)
+	return nil
+}
+
+func (q *QdrantConnector) Fetch(ctx context.Context, query Query) (map[string]data.Data, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if err := query.validate(); err != nil {
+		logger.Error("invalid query", slog.String("component", "sink"), slog.Any("error", err))
+		return nil, fmt.Errorf("invalid query: %w", err)
 	}
+	collection := query.Collection
+	id := query.ReferenceID
+
+	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
+	vector := query.Vector
+	var reference map[string]*qdrant.Value
+	if len(vector) == 0 {
+		// getting the point based on ID
+		limit := uint32(1)
+		scrollResp, err := pointsClient.Scroll(ctx, &qdrant.ScrollPoints{
+			CollectionName: collection,
+			Filter: qdrantFilter(Filter{
+				Must: []Condition{Equals("mail_id", id), Equals("consumed", false)},
+			}),
+			Limit: &limit,
+			WithPayload: &qdrant.WithPayloadSelector{
+				SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true},
+			},
+			WithVectors: &qdrant.WithVectorsSelector{
+				SelectorOptions: &qdrant.WithVectorsSelector_Enable{Enable: true},
+			},
+		})
+		if err != nil {
+			logger.Error("could not fetch reference point by payload id", slog.String("id", id), slog.Any("error", err))
+			return nil, fmt.Errorf("failed to fetch reference point: %w", err)
+		}
+		if len(scrollResp.Result) == 0 || scrollResp.Result[0].Vectors == nil {
+			logger.Error("could not find reference point by payload id", slog.String("id", id), slog.Any("error", err), slog.String("component", "sink"))
+			return nil, fmt.Errorf("reference point with id %s not found or has no vectors: %w", id, ErrReferenceNotFound)
+		}
 
-	logger.Info("successfully fetched reference point", slog.String("id", id), slog.String("component", "sink"))
+		logger.Info("successfully fetched reference point", slog.String("id", id), slog.String("component", "sink"))
 
-	// Extract vector
-	vectorsOutput := scrollResp.Result[0].Vectors
-	vector := vectorsOutput.GetVector().Data
+		// Extract vector
+		vector = denseVector(scrollResp.Result[0].Vectors)
+		reference = scrollResp.Result[0].Payload
+	}
+	filter := qdrantFilter(query.Filter)
 
 	// Perform search
-	searchResp, err := pointsClient.Search(ctx, &qdrant.SearchPoints{
-		CollectionName: collection,
-		Vector:         vector,
-		Filter: &qdrant.Filter{
-			Must: []*qdrant.Condition{
-				{
-					ConditionOneOf: &qdrant.Condition_Field{
-						Field: &qdrant.FieldCondition{
-							Key: "consumed",
-							Match: &qdrant.Match{
-								MatchValue: &qdrant.Match_Boolean{Boolean: false},
-							},
-						},
-					},
-				},
+	var hits []*qdrant.ScoredPoint
+	var err error
+	if q.config.Hybrid.Enabled && reference != nil {
+		hits, err = q.hybridSearch(ctx, collection, reference, vector, filter, query.Limit, query.Offset)
+	} else {
+		offset := uint64(query.Offset)
+		var searchResp *qdrant.SearchResponse
+		searchResp, err = pointsClient.Search(ctx, &qdrant.SearchPoints{
+			CollectionName: collection,
+			Vector:         vector,
+			Filter:         filter,
+			Limit:          uint64(query.Limit),
+			Offset:         &offset,
+			WithPayload: &qdrant.WithPayloadSelector{
+				SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true},
 			},
-		},
-		Limit: uint64(count),
-		WithPayload: &qdrant.WithPayloadSelector{
-			SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true},
-		},
-	})
+		})
+		hits = searchResp.GetResult()
+	}
 	if err != nil {
 		logger.Error("could not search for vectors",
 			slog.String("collection", collection),
 			slog.String("component", "sink"),
 			slog.Any("error", err),
-			slog.String("collection", collection),
 			slog.String("id", id))
 		return nil, fmt.Errorf("failed to search for vectors: %w", err)
 	}
 
-	// convert the vectors to []data.Data
-	results := make(map[string]data.Data)
-	for _, point := range searchResp.Result {
-		results[point.Id.GetUuid()] = data.FromQdrantPayload(point.Payload)
+	// convert the vectors to []data.Data, collapsing the chunks back to the documents they belong to
+	stored := make([]storedPoint, 0, len(hits))
+	for _, point := range hits {
+		stored = append(stored, storedPoint{id: point.Id.GetUuid(), payload: point.Payload})
+	}
+	results, missingParents := collapseChunks(ctx, stored)
+
+	if len(missingParents) > 0 {
+		parentIDs := make([]*qdrant.PointId, 0, len(missingParents))
+		for _, id := range missingParents {
+			parentIDs = append(parentIDs, &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: id}})
+		}
+		getResp, err := pointsClient.Get(ctx, &qdrant.GetPoints{
+			CollectionName: collection,
+			Ids:            parentIDs,
+			WithPayload: &qdrant.WithPayloadSelector{
+				SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true},
+			},
+		})
+		if err != nil {
+			logger.Error("could not fetch the parents of the chunks", slog.String("collection", collection), slog.String("component", "sink"), slog.Any("error", err))
+			return nil, fmt.Errorf("failed to fetch chunk parents: %w", err)
+		}
+		for _, point := range getResp.Result {
+			decodePoint(ctx, point.Id.GetUuid(), point.Payload, results)
+		}
 	}
 
 	return results, nil
 }
 
+func (q *QdrantConnector) FetchThread(ctx context.Context, root string) ([]data.Data, []string, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
+	limit := uint32(threadPageSize)
+	points := make([]*qdrant.RetrievedPoint, 0)
+	var offset *qdrant.PointId
+	for {
+		scrollResp, err := pointsClient.Scroll(ctx, &qdrant.ScrollPoints{
+			CollectionName: q.config.Collection,
+			Filter: &qdrant.Filter{
+				Must: []*qdrant.Condition{
+					{
+						ConditionOneOf: &qdrant.Condition_Field{
+							Field: &qdrant.FieldCondition{
+								Key: "thread_root",
+								Match: &qdrant.Match{
+									MatchValue: &qdrant.Match_Keyword{Keyword: root},
+								},
+							},
+						},
+					},
+				},
+			},
+			Offset: offset,
+			Limit:  &limit,
+			WithPayload: &qdrant.WithPayloadSelector{
+				SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true},
+			},
+		})
+		if err != nil {
+			logger.Error("could not fetch thread", slog.String("root", root), slog.String("component", "sink"), slog.Any("error", err))
+			return nil, nil, fmt.Errorf("failed to fetch thread: %w", err)
+		}
+		points = append(points, scrollResp.Result...)
+		offset = scrollResp.NextPageOffset
+		if offset == nil {
+			break
+		}
+	}
+
+	// chronological order, with the position in the reply chain breaking ties between mails sent in the same second
+	sort.SliceStable(points, func(i, j int) bool {
+		di, dj := points[i].Payload["date"].GetDoubleValue(), points[j].Payload["date"].GetDoubleValue()
+		if di != dj {
+			return di < dj
+		}
+		return points[i].Payload["thread_position"].GetIntegerValue() < points[j].Payload["thread_position"].GetIntegerValue()
+	})
+
+	thread := make([]data.Data, 0, len(points))
+	ids := make([]string, 0, len(points))
+	for _, point := range points {
+		// a chunked mail is represented by its first chunk
+		if index, _ := data.IsChunk(point.Payload); index > 0 {
+			continue
+		}
+		d, err := data.FromQdrantPayload(point.Payload)
+		if err != nil {
+			logger.Warn("skipping point with an undecodable payload", slog.String("id", point.Id.GetUuid()), slog.String("component", "sink"), slog.Any("error", err))
+			continue
+		}
+		thread = append(thread, d)
+		ids = append(ids, point.Id.GetUuid())
+	}
+	logger.Info("fetched thread", slog.String("root", root), slog.Int("count", len(thread)), slog.String("component", "sink"))
+	return thread, ids, nil
+}
+
+// MarkConsumed marks the points as consumed, along with all the chunks of the documents whose first chunk is among them
 func (q *QdrantConnector) MarkConsumed(ctx context.Context, ids []string) error {
 	logger := ctx.Value("logger").(*slog.Logger)
 	uuids := make([]*qdrant.PointId, 0, len(ids))
@@ -243,7 +441,23 @@ func (q *QdrantConnector) MarkConsumed(ctx context.Context, ids []string) error
 		_, err := pointsClient.SetPayload(ctx, &qdrant.SetPayloadPoints{
 			CollectionName: q.config.Collection,
 			PointsSelector: &qdrant.PointsSelector{
-				PointsSelectorOneOf: &qdrant.PointsSelector_Points{Points: &qdrant.PointsIdsList{Ids: uuids}},
+				PointsSelectorOneOf: &qdrant.PointsSelector_Filter{Filter: &qdrant.Filter{
+					Should: []*qdrant.Condition{
+						{
+							ConditionOneOf: &qdrant.Condition_HasId{HasId: &qdrant.HasIdCondition{HasId: uuids}},
+						},
+						{
+							ConditionOneOf: &qdrant.Condition_Field{
+								Field: &qdrant.FieldCondition{
+									Key: "parent_id",
+									Match: &qdrant.Match{
+										MatchValue: &qdrant.Match_Keywords{Keywords: &qdrant.RepeatedStrings{Strings: ids}},
+									},
+								},
+							},
+						},
+					},
+				}},
 			},
 			Payload: map[string]*qdrant.Value{
 				"consumed": {Kind: &qdrant.Value_BoolValue{BoolValue: true}},
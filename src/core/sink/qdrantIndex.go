@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/qdrant/go-client/qdrant"
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// qdrantIndexTypes are the index types of the schema field types, with the type qdrant reports an existing index of
+var qdrantIndexTypes = map[data.FieldType]struct {
+	field  qdrant.FieldType
+	schema qdrant.PayloadSchemaType
+}{
+	data.FieldKeyword:  {qdrant.FieldType_FieldTypeKeyword, qdrant.PayloadSchemaType_Keyword},
+	data.FieldInteger:  {qdrant.FieldType_FieldTypeInteger, qdrant.PayloadSchemaType_Integer},
+	data.FieldFloat:    {qdrant.FieldType_FieldTypeFloat, qdrant.PayloadSchemaType_Float},
+	data.FieldBool:     {qdrant.FieldType_FieldTypeBool, qdrant.PayloadSchemaType_Bool},
+	data.FieldDatetime: {qdrant.FieldType_FieldTypeDatetime, qdrant.PayloadSchemaType_Datetime},
+	data.FieldText:     {qdrant.FieldType_FieldTypeText, qdrant.PayloadSchemaType_Text},
+}
+
+// ensurePayloadIndexes creates the payload indexes of the schema the collection is missing, and recreates the ones of
+// another type. Indexes outside the schema are left alone, as they may have been created by hand. Qdrant builds the
+// indexes in the background, so the points stay searchable while a large collection is indexed
+func (q *QdrantConnector) ensurePayloadIndexes(ctx context.Context) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	schema := data.PayloadSchema()
+	maps.Copy(schema, sinkSchema)
+
+	collectionsClient := qdrant.NewCollectionsClient(q.grpcConnection)
+	info, err := collectionsClient.Get(ctx, &qdrant.GetCollectionInfoRequest{CollectionName: q.config.Collection})
+	if err != nil {
+		logger.Error("failed to get collection", slog.String("collection", q.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
+		return fmt.Errorf("failed to check collection: %w", err)
+	}
+	existing := info.GetResult().GetPayloadSchema()
+
+	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
+	created := 0
+	for _, field := range slices.Sorted(maps.Keys(schema)) {
+		indexType, ok := qdrantIndexTypes[schema[field]]
+		if !ok {
+			return fmt.Errorf("field type %s of %s has no qdrant index", schema[field], field)
+		}
+		current, indexed := existing[field]
+		if indexed && current.GetDataType() == indexType.schema {
+			continue
+		}
+		if indexed {
+			logger.Warn("recreating payload index of another type", slog.String("collection", q.config.Collection), slog.String("field", field), slog.String("indexType", current.GetDataType().String()), slog.String("schemaType", string(schema[field])), slog.String("component", "sink"))
+			// the index has to be gone before one of the new type can be created
+			wait := true
+			_, err = pointsClient.DeleteFieldIndex(ctx, &qdrant.DeleteFieldIndexCollection{
+				CollectionName: q.config.Collection,
+				FieldName:      field,
+				Wait:           &wait,
+			})
+			if err != nil {
+				logger.Error("failed to delete payload index", slog.String("collection", q.config.Collection), slog.String("field", field), slog.String("component", "sink"), slog.Any("error", err))
+				return fmt.Errorf("failed to delete payload index of %s: %w", field, err)
+			}
+		}
+
+		fieldType := indexType.field
+		request := &qdrant.CreateFieldIndexCollection{
+			CollectionName: q.config.Collection,
+			FieldName:      field,
+			FieldType:      &fieldType,
+		}
+		if schema[field] == data.FieldText {
+			// words are matched ignoring case, as the text conditions of a query are
+			lowercase := true
+			request.FieldIndexParams = &qdrant.PayloadIndexParams{
+				IndexParams: &qdrant.PayloadIndexParams_TextIndexParams{
+					TextIndexParams: &qdrant.TextIndexParams{Tokenizer: qdrant.TokenizerType_Word, Lowercase: &lowercase},
+				},
+			}
+		}
+		_, err = pointsClient.CreateFieldIndex(ctx, request)
+		if err != nil {
+			logger.Error("failed to create payload index", slog.String("collection", q.config.Collection), slog.String("field", field), slog.String("component", "sink"), slog.Any("error", err))
+			return fmt.Errorf("failed to create payload index of %s: %w", field, err)
+		}
+		created++
+	}
+	logger.Info("payload indexes match the schema", slog.String("collection", q.config.Collection), slog.Int("created", created), slog.Int("fields", len(schema)), slog.String("component", "sink"))
+	return nil
+}
@@ -0,0 +1,190 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/qdrant/go-client/qdrant"
+	"log/slog"
+	"strconv"
+)
+
+// ResolveAlias returns the collection the configured collection name points to, and whether the name is an alias
+// rather than the collection itself
+func (q *QdrantConnector) ResolveAlias(ctx context.Context) (string, bool, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	collectionsClient := qdrant.NewCollectionsClient(q.grpcConnection)
+	aliases, err := collectionsClient.ListAliases(ctx, &qdrant.ListAliasesRequest{})
+	if err != nil {
+		logger.Error("failed to list aliases", slog.String("component", "sink"), slog.Any("error", err))
+		return "", false, fmt.Errorf("failed to list aliases: %w", err)
+	}
+	for _, alias := range aliases.GetAliases() {
+		if alias.GetAliasName() == q.config.Collection {
+			return alias.GetCollectionName(), true, nil
+		}
+	}
+	return q.config.Collection, false, nil
+}
+
+// Count returns the exact number of points in the collection
+func (q *QdrantConnector) Count(ctx context.Context, collection string) (uint64, error) {
+	exact := true
+	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
+	countResp, err := pointsClient.Count(ctx, &qdrant.CountPoints{CollectionName: collection, Exact: &exact})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count points: %w", err)
+	}
+	return countResp.GetResult().GetCount(), nil
+}
+
+// ScrollPage returns a page of the points of the collection with their payload, starting at offset. The offset of
+// the next page is empty once the last page has been read
+func (q *QdrantConnector) ScrollPage(ctx context.Context, collection string, offset string, limit uint32) ([]*qdrant.RetrievedPoint, string, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	var start *qdrant.PointId
+	if offset != "" {
+		start = parsePointID(offset)
+	}
+	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
+	scrollResp, err := pointsClient.Scroll(ctx, &qdrant.ScrollPoints{
+		CollectionName: collection,
+		Offset:         start,
+		Limit:          &limit,
+		WithPayload: &qdrant.WithPayloadSelector{
+			SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true},
+		},
+	})
+	if err != nil {
+		logger.Error("could not scroll the collection", slog.String("collection", collection), slog.String("offset", offset), slog.String("component", "sink"), slog.Any("error", err))
+		return nil, "", fmt.Errorf("failed to scroll points: %w", err)
+	}
+	next := ""
+	if scrollResp.NextPageOffset != nil {
+		next = formatPointID(scrollResp.NextPageOffset)
+	}
+	return scrollResp.Result, next, nil
+}
+
+// Reembed embeds the points again with the engine of the connector and writes them to its collection, keeping their
+// ids and payloads so that chunk parents and consumed flags survive the migration
+func (q *QdrantConnector) Reembed(ctx context.Context, retrieved []*qdrant.RetrievedPoint) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if len(retrieved) == 0 {
+		return nil
+	}
+	texts := make([]string, 0, len(retrieved))
+	for _, point := range retrieved {
+		text, err := data.EmbeddingText(point.Payload)
+		if err != nil {
+			logger.Error("could not decode the payload", slog.String("id", formatPointID(point.Id)), slog.String("component", "sink"), slog.Any("error", err))
+			return fmt.Errorf("failed to decode the payload of %s: %w", formatPointID(point.Id), err)
+		}
+		texts = append(texts, text)
+	}
+	embeddings, err := q.generator.EmbedBatch(ctx, texts)
+	if err != nil {
+		return err
+	}
+
+	points := make([]*qdrant.PointStruct, 0, len(retrieved))
+	for i, point := range retrieved {
+		if len(embeddings[i]) == 0 {
+			return fmt.Errorf("embedding of %s is empty", formatPointID(point.Id))
+		}
+		points = append(points, &qdrant.PointStruct{
+			Id:      point.Id,
+			Payload: point.Payload,
+			Vectors: q.pointVectors(texts[i], embeddings[i]),
+		})
+	}
+
+	// waiting for the write, as the caller checkpoints its progress once this returns
+	wait := true
+	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
+	_, err = pointsClient.Upsert(ctx, &qdrant.UpsertPoints{
+		CollectionName: q.config.Collection,
+		Wait:           &wait,
+		Points:         points,
+	})
+	if err != nil {
+		logger.Error("could not upsert the points", slog.String("collection", q.config.Collection), slog.String("component", "sink"), slog.Any("error", err))
+		return fmt.Errorf("failed to upsert points: %w", err)
+	}
+	return nil
+}
+
+// SwapAlias points the alias to the collection. Moving an existing alias is a single request that qdrant applies
+// atomically, and so is creating a new one. A collection with the name of the alias has to be dropped before the
+// alias can be created, which leaves the name unresolvable from the delete until the alias exists, so that is only
+// done when replaceCollection is set. It reports whether such a collection was dropped
+func (q *QdrantConnector) SwapAlias(ctx context.Context, alias string, collection string, replaceCollection bool) (bool, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	collectionsClient := qdrant.NewCollectionsClient(q.grpcConnection)
+	aliases, err := collectionsClient.ListAliases(ctx, &qdrant.ListAliasesRequest{})
+	if err != nil {
+		logger.Error("failed to list aliases", slog.String("component", "sink"), slog.Any("error", err))
+		return false, fmt.Errorf("failed to list aliases: %w", err)
+	}
+	isAlias := false
+	for _, existing := range aliases.GetAliases() {
+		if existing.GetAliasName() == alias {
+			isAlias = true
+			break
+		}
+	}
+
+	dropped := false
+	actions := make([]*qdrant.AliasOperations, 0, 2)
+	if isAlias {
+		actions = append(actions, &qdrant.AliasOperations{
+			Action: &qdrant.AliasOperations_DeleteAlias{DeleteAlias: &qdrant.DeleteAlias{AliasName: alias}},
+		})
+	} else {
+		exists, err := collectionsClient.CollectionExists(ctx, &qdrant.CollectionExistsRequest{CollectionName: alias})
+		if err != nil {
+			return false, fmt.Errorf("failed to check collection: %w", err)
+		}
+		if exists.GetResult().GetExists() {
+			if !replaceCollection {
+				return false, fmt.Errorf("%s is a collection rather than an alias, it has to be dropped to create the alias", alias)
+			}
+			logger.Warn("dropping the collection to replace it with an alias, the name does not resolve until the alias is created", slog.String("collection", alias), slog.String("component", "sink"))
+			_, err = collectionsClient.Delete(ctx, &qdrant.DeleteCollection{CollectionName: alias})
+			if err != nil {
+				logger.Error("failed to delete collection", slog.String("collection", alias), slog.String("component", "sink"), slog.Any("error", err))
+				return false, fmt.Errorf("failed to delete collection: %w", err)
+			}
+			dropped = true
+		}
+	}
+	actions = append(actions, &qdrant.AliasOperations{
+		Action: &qdrant.AliasOperations_CreateAlias{CreateAlias: &qdrant.CreateAlias{CollectionName: collection, AliasName: alias}},
+	})
+
+	_, err = collectionsClient.UpdateAliases(ctx, &qdrant.ChangeAliases{Actions: actions})
+	if err != nil {
+		logger.Error("failed to swap the alias", slog.String("alias", alias), slog.String("collection", collection), slog.String("component", "sink"), slog.Any("error", err))
+		return dropped, fmt.Errorf("failed to swap alias: %w", err)
+	}
+	logger.Info("swapped the alias", slog.String("alias", alias), slog.String("collection", collection), slog.String("component", "sink"))
+	return dropped, nil
+}
+
+func parsePointID(id string) *qdrant.PointId {
+	if num, err := strconv.ParseUint(id, 10, 64); err == nil {
+		return &qdrant.PointId{PointIdOptions: &qdrant.PointId_Num{Num: num}}
+	}
+	return &qdrant.PointId{PointIdOptions: &qdrant.PointId_Uuid{Uuid: id}}
+}
+
+func formatPointID(id *qdrant.PointId) string {
+	if uuid, ok := id.GetPointIdOptions().(*qdrant.PointId_Uuid); ok {
+		return uuid.Uuid
+	}
+	return strconv.FormatUint(id.GetNum(), 10)
+}
@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"github.com/qdrant/go-client/qdrant"
+	"strings"
+)
+
+// qdrantFilter translates the filter into the conditions of a qdrant filter
+func qdrantFilter(filter Filter) *qdrant.Filter {
+	translated := &qdrant.Filter{}
+	for _, condition := range filter.Must {
+		translated.Must = append(translated.Must, qdrantCondition(condition))
+	}
+	for _, condition := range filter.MustNot {
+		translated.MustNot = append(translated.MustNot, qdrantCondition(condition))
+	}
+	return translated
+}
+
+// qdrantCondition translates a validated condition. A keyword match on a list matches any of its elements, which is
+// what equality on a list means in the query
+func qdrantCondition(condition Condition) *qdrant.Condition {
+	field := &qdrant.FieldCondition{Key: condition.Field}
+	switch condition.Operator {
+	case OperatorEquals:
+		value, _ := scalar(condition.Value)
+		field.Match = qdrantMatch(value)
+	case OperatorIn:
+		keywords := make([]string, 0, len(condition.Values))
+		integers := make([]int64, 0, len(condition.Values))
+		for _, value := range condition.Values {
+			normalized, _ := scalar(value)
+			switch v := normalized.(type) {
+			case string:
+				keywords = append(keywords, v)
+			case int64:
+				integers = append(integers, v)
+			}
+		}
+		if len(keywords) > 0 {
+			field.Match = &qdrant.Match{MatchValue: &qdrant.Match_Keywords{Keywords: &qdrant.RepeatedStrings{Strings: keywords}}}
+		} else {
+			field.Match = &qdrant.Match{MatchValue: &qdrant.Match_Integers{Integers: &qdrant.RepeatedIntegers{Integers: integers}}}
+		}
+	case OperatorRange:
+		field.Range = &qdrant.Range{Gt: condition.Range.Gt, Gte: condition.Range.Gte, Lt: condition.Range.Lt, Lte: condition.Range.Lte}
+	case OperatorText:
+		// the text fields have a lowercased word index, which qdrant matches every word of the text against
+		field.Match = &qdrant.Match{MatchValue: &qdrant.Match_Text{Text: strings.Join(textWords(condition.Value.(string)), " ")}}
+	}
+	return &qdrant.Condition{ConditionOneOf: &qdrant.Condition_Field{Field: field}}
+}
+
+func qdrantMatch(value any) *qdrant.Match {
+	switch v := value.(type) {
+	case bool:
+		return &qdrant.Match{MatchValue: &qdrant.Match_Boolean{Boolean: v}}
+	case int64:
+		return &qdrant.Match{MatchValue: &qdrant.Match_Integer{Integer: v}}
+	default:
+		return &qdrant.Match{MatchValue: &qdrant.Match_Keyword{Keyword: v.(string)}}
+	}
+}
@@ -0,0 +1,192 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query selects what Fetch returns, the points closest to the reference that satisfy the filter. Limit bounds the
+// number of points searched for, after skipping the Offset closest ones, and chunks collapsing into their documents
+// can leave fewer results than that
+type Query struct {
+	Collection string
+	// ReferenceID is the mail id of the unconsumed point whose vector is searched with, it is ignored when Vector is
+	// set. Only a reference point gives the text hybrid search needs, a Vector is searched with the dense vector alone
+	ReferenceID string
+	Vector      []float32
+	Filter      Filter
+	Limit       int
+	Offset      int
+}
+
+// Filter is a conjunction of conditions, a point satisfies it when it satisfies every condition of Must and none of
+// MustNot. A condition on a field the point does not have is not satisfied
+type Filter struct {
+	Must    []Condition
+	MustNot []Condition
+}
+
+type Operator int
+
+const (
+	// OperatorEquals matches a field holding the value, or a list holding it among its elements
+	OperatorEquals Operator = iota
+	// OperatorIn matches a field holding any of the values
+	OperatorIn
+	// OperatorRange matches a number within the range
+	OperatorRange
+	// OperatorText matches a text containing every word of the value, ignoring case. Words are the runs of letters and
+	// digits, so "lock" matches "take the Lock." but not "spinlock". It applies to the text fields of the payload
+	// schema, which qdrant keeps a lowercased word index of
+	OperatorText
+)
+
+// Condition is a predicate on a payload field. Values are strings, booleans or integers, the constructors below
+// build the conditions of every operator
+type Condition struct {
+	Field    string
+	Operator Operator
+	Value    any
+	Values   []any
+	Range    Range
+}
+
+// Range bounds a number, a nil bound leaves that side open
+type Range struct {
+	Gt  *float64
+	Gte *float64
+	Lt  *float64
+	Lte *float64
+}
+
+func Equals(field string, value any) Condition {
+	return Condition{Field: field, Operator: OperatorEquals, Value: value}
+}
+
+func In(field string, values ...any) Condition {
+	return Condition{Field: field, Operator: OperatorIn, Values: values}
+}
+
+func InRange(field string, r Range) Condition {
+	return Condition{Field: field, Operator: OperatorRange, Range: r}
+}
+
+func Text(field string, text string) Condition {
+	return Condition{Field: field, Operator: OperatorText, Value: text}
+}
+
+// Since matches a date field at or after the time, dates are stored as unix seconds
+func Since(field string, t time.Time) Condition {
+	seconds := float64(t.Unix())
+	return InRange(field, Range{Gte: &seconds})
+}
+
+// Before matches a date field strictly before the time
+func Before(field string, t time.Time) Condition {
+	seconds := float64(t.Unix())
+	return InRange(field, Range{Lt: &seconds})
+}
+
+// validate checks that the query can be translated, so that every sink rejects the same queries
+func (q Query) validate() error {
+	if q.Collection == "" {
+		return errors.New("no collection specified")
+	}
+	if q.ReferenceID == "" && len(q.Vector) == 0 {
+		return errors.New("no reference id or vector specified")
+	}
+	if q.Limit <= 0 {
+		return fmt.Errorf("invalid limit %d", q.Limit)
+	}
+	if q.Offset < 0 {
+		return fmt.Errorf("invalid offset %d", q.Offset)
+	}
+	for _, conditions := range [][]Condition{q.Filter.Must, q.Filter.MustNot} {
+		for _, condition := range conditions {
+			if err := condition.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c Condition) validate() error {
+	if c.Field == "" {
+		return errors.New("condition has no field")
+	}
+	switch c.Operator {
+	case OperatorEquals:
+		if _, err := scalar(c.Value); err != nil {
+			return fmt.Errorf("condition on %s: %w", c.Field, err)
+		}
+	case OperatorIn:
+		if len(c.Values) == 0 {
+			return fmt.Errorf("condition on %s has no values", c.Field)
+		}
+		_, isString := c.Values[0].(string)
+		for _, value := range c.Values {
+			v, err := scalar(value)
+			if err != nil {
+				return fmt.Errorf("condition on %s: %w", c.Field, err)
+			}
+			if _, ok := v.(bool); ok {
+				return fmt.Errorf("condition on %s: in takes strings or integers", c.Field)
+			}
+			if _, ok := v.(string); ok != isString {
+				return fmt.Errorf("condition on %s: in takes either strings or integers", c.Field)
+			}
+		}
+	case OperatorRange:
+		if c.Range.Gt == nil && c.Range.Gte == nil && c.Range.Lt == nil && c.Range.Lte == nil {
+			return fmt.Errorf("condition on %s has no bounds", c.Field)
+		}
+	case OperatorText:
+		text, ok := c.Value.(string)
+		if !ok {
+			return fmt.Errorf("condition on %s: text takes a string", c.Field)
+		}
+		if len(textWords(text)) == 0 {
+			return fmt.Errorf("condition on %s: text has no words", c.Field)
+		}
+		if data.PayloadSchema()[c.Field] != data.FieldText {
+			return fmt.Errorf("condition on %s: text only applies to the text fields", c.Field)
+		}
+	default:
+		return fmt.Errorf("condition on %s has an unknown operator %d", c.Field, c.Operator)
+	}
+	return nil
+}
+
+// textWords splits a text into the lowercased words text conditions match, the way the word tokenizer of qdrant does
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// scalar normalizes a value to a string, a bool or an int64
+func scalar(value any) (any, error) {
+	switch v := value.(type) {
+	case string, bool, int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	default:
+		return nil, fmt.Errorf("values of type %T are not supported", value)
+	}
+}
+
+// within reports whether the number satisfies the bounds of the range
+func (r Range) within(number float64) bool {
+	return (r.Gt == nil || number > *r.Gt) &&
+		(r.Gte == nil || number >= *r.Gte) &&
+		(r.Lt == nil || number < *r.Lt) &&
+		(r.Lte == nil || number <= *r.Lte)
+}
@@ -0,0 +1,94 @@
+package sink
+
+import (
+	"github.com/qdrant/go-client/qdrant"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPgVectorWhere(t *testing.T) {
+	since := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		filter Filter
+		clause string
+		vars   []any
+	}{
+		{"empty", Filter{}, "", []any{}},
+		{"column", Filter{Must: []Condition{Equals("consumed", false)}}, "(consumed = ?)", []any{false}},
+		{"payload string", Filter{Must: []Condition{Equals("list", "netdev")}},
+			"(payload->'fields'->?->>'stringValue' = ? OR payload->'fields'->?->'listValue'->'values' @> ?::jsonb)",
+			[]any{"list", "netdev", "list", `[{"stringValue":"netdev"}]`}},
+		{"payload integer", Filter{Must: []Condition{Equals("patch_version", 2)}},
+			"(payload->'fields'->?->>'integerValue' = ? OR payload->'fields'->?->'listValue'->'values' @> ?::jsonb)",
+			[]any{"patch_version", "2", "patch_version", `[{"integerValue":"2"}]`}},
+		{"column in", Filter{Must: []Condition{In("mail_id", "a", "b")}}, "(mail_id IN ?)", []any{[]any{"a", "b"}}},
+		{"range", Filter{Must: []Condition{Since("date", since)}}, "((date) >= ?)", []any{float64(since.Unix())}},
+		{"text words", Filter{Must: []Condition{Text("subject", "Net_DEV 100%")}},
+			"(payload->'fields'->?->>'stringValue' ~* ? AND payload->'fields'->?->>'stringValue' ~* ? AND payload->'fields'->?->>'stringValue' ~* ?)",
+			[]any{"subject", "(^|[^[:alnum:]])net($|[^[:alnum:]])", "subject", "(^|[^[:alnum:]])dev($|[^[:alnum:]])", "subject", "(^|[^[:alnum:]])100($|[^[:alnum:]])"}},
+		{"not keeps missing fields", Filter{Must: []Condition{Equals("consumed", false)}, MustNot: []Condition{Equals("mail_id", "a")}},
+			"(consumed = ?) AND NOT coalesce((mail_id = ?), false)", []any{false, "a"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clause, vars := pgVectorWhere(test.filter)
+			if clause != test.clause {
+				t.Errorf("clause = %q, want %q", clause, test.clause)
+			}
+			if !reflect.DeepEqual(vars, test.vars) {
+				t.Errorf("vars = %#v, want %#v", vars, test.vars)
+			}
+		})
+	}
+}
+
+func TestQdrantFilter(t *testing.T) {
+	filter := qdrantFilter(Filter{
+		Must:    []Condition{Equals("consumed", false), In("patch_version", 1, 2), Text("subject", "Page-Alloc: leak!")},
+		MustNot: []Condition{Equals("list", "netdev")},
+	})
+	if len(filter.Must) != 3 || len(filter.MustNot) != 1 {
+		t.Fatalf("translated into %d must and %d must not conditions, want 3 and 1", len(filter.Must), len(filter.MustNot))
+	}
+	if text := filter.Must[2].GetField().GetMatch().GetText(); text != "page alloc leak" {
+		t.Errorf("subject matches the text %q, want the words of the condition", text)
+	}
+	if match := filter.Must[0].GetField().GetMatch(); match.GetMatchValue().(*qdrant.Match_Boolean).Boolean != false {
+		t.Errorf("consumed matches %v, want false", match)
+	}
+	if integers := filter.Must[1].GetField().GetMatch().GetIntegers().GetIntegers(); !reflect.DeepEqual(integers, []int64{1, 2}) {
+		t.Errorf("patch_version matches %v, want [1 2]", integers)
+	}
+	if keyword := filter.MustNot[0].GetField().GetMatch().GetKeyword(); keyword != "netdev" {
+		t.Errorf("list matches %q, want netdev", keyword)
+	}
+}
+
+func TestQueryValidate(t *testing.T) {
+	valid := Query{Collection: "mails", ReferenceID: "a", Limit: 1}
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		query func(q Query) Query
+	}{
+		{"no reference", func(q Query) Query { q.ReferenceID = ""; return q }},
+		{"no limit", func(q Query) Query { q.Limit = 0; return q }},
+		{"negative offset", func(q Query) Query { q.Offset = -1; return q }},
+		{"float value", func(q Query) Query { q.Filter.Must = []Condition{Equals("date", 1.5)}; return q }},
+		{"mixed in", func(q Query) Query { q.Filter.Must = []Condition{In("list", "netdev", 2)}; return q }},
+		{"open range", func(q Query) Query { q.Filter.Must = []Condition{InRange("date", Range{})}; return q }},
+		{"text without words", func(q Query) Query { q.Filter.Must = []Condition{Text("subject", " -- ")}; return q }},
+		{"text on a keyword", func(q Query) Query { q.Filter.Must = []Condition{Text("sender", "jane")}; return q }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.query(valid).validate(); err == nil {
+				t.Error("query was accepted")
+			}
+		})
+	}
+}
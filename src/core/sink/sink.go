@@ -2,6 +2,7 @@ package sink
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
@@ -9,9 +10,21 @@ import (
 	"log/slog"
 )
 
+// ErrReferenceNotFound is returned by Fetch when the reference of the query is not stored or already consumed, a
+// message for it has nothing left to process
+var ErrReferenceNotFound = errors.New("reference not found")
+
 type Sink interface {
-	Upsert(ctx context.Context, dataList []data.Data, size int) ([]data.Metadata, error)
-	Fetch(ctx context.Context, filters map[string]string) (map[string]data.Data, error)
+	// Upsert stores the data under ids derived from their keys, so that ingesting a document again replaces it. It
+	// returns the metadata of the documents to enqueue, every document of the batch not consumed yet, so that a document
+	// whose enqueue failed is enqueued again the next time it is ingested. The buffer drops the copies it already holds
+	Upsert(ctx context.Context, dataList []data.Data) ([]data.Metadata, error)
+	// Fetch returns the documents closest to the reference of the query that satisfy its filter, keyed by point id.
+	// Chunks are collapsed into the document they belong to, keyed by the point of its first chunk
+	Fetch(ctx context.Context, query Query) (map[string]data.Data, error)
+	// FetchThread returns every stored mail of the thread started by root, oldest first, along with the ids of their
+	// points
+	FetchThread(ctx context.Context, root string) ([]data.Data, []string, error)
 	MarkConsumed(ctx context.Context, ids []string) error
 	GetCollection(ctx context.Context) string
 }
@@ -32,7 +45,29 @@ func NewSink(ctx context.Context, sinkConfig config.RawSink, generator engine.En
 			return nil, err
 		}
 		return qdrantConnector, nil
+	case "pgvector":
+		pgVectorConfig, ok := sinkConfig.Value.(config.PgVectorConfig)
+		if !ok {
+			logger.Error("failed to cast pgvector config", slog.String("type", sinkConfig.Type), slog.String("component", "Sink"))
+			return nil, fmt.Errorf("database config is not a pgvector config")
+		}
+		pgVectorConnector, err := NewPgVectorConnector(ctx, pgVectorConfig, generator)
+		if err != nil {
+			return nil, err
+		}
+		return pgVectorConnector, nil
+	case "memory":
+		memoryConfig, ok := sinkConfig.Value.(config.MemoryConfig)
+		if !ok {
+			logger.Error("failed to cast memory config", slog.String("type", sinkConfig.Type), slog.String("component", "Sink"))
+			return nil, fmt.Errorf("database config is not a memory config")
+		}
+		memoryConnector, err := NewMemoryConnector(ctx, memoryConfig, generator)
+		if err != nil {
+			return nil, err
+		}
+		return memoryConnector, nil
 	default:
-		return nil, fmt.Errorf("database config is not a qdrant config")
+		return nil, fmt.Errorf("database type %s is not supported", sinkConfig.Type)
 	}
 }
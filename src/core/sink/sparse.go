@@ -0,0 +1,179 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/qdrant/go-client/qdrant"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// sparseVectorName is the named vector holding the BM25 term weights, next to the unnamed dense vector
+	sparseVectorName     = "bm25"
+	bm25K1               = 1.2
+	bm25B                = 0.75
+	defaultAverageLength = 256
+)
+
+// termPattern matches words and identifiers, keeping the paths and dotted or dashed names like mm/page_alloc.c and
+// CONFIG_DEBUG_VM together as a single term
+var termPattern = regexp.MustCompile(`[\p{L}\p{N}_]+(?:[./\-:]+[\p{L}\p{N}_]+)*`)
+
+// termSeparator splits a compound term into the parts that are searched for on their own
+var termSeparator = regexp.MustCompile(`[./\-:]+`)
+
+// sparseTerms returns the lowercased terms of the text. A compound term is kept whole and also contributes its
+// parts, so that a path matches both the full path and the names of its directories and file
+func sparseTerms(text string) []string {
+	terms := make([]string, 0)
+	for _, match := range termPattern.FindAllString(strings.ToLower(text), -1) {
+		terms = append(terms, match)
+		parts := termSeparator.Split(match, -1)
+		if len(parts) > 1 {
+			terms = append(terms, parts...)
+		}
+	}
+	return terms
+}
+
+// termIndex maps a term to its dimension of the sparse vector
+func termIndex(term string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(term))
+	return h.Sum32()
+}
+
+// bm25Encoder computes the term frequency part of BM25 locally, qdrant applies the inverse document frequency at
+// query time as the sparse vector is configured with the IDF modifier
+type bm25Encoder struct {
+	averageLength float64
+}
+
+func newBM25Encoder(averageLength float64) bm25Encoder {
+	if averageLength <= 0 {
+		averageLength = defaultAverageLength
+	}
+	return bm25Encoder{averageLength: averageLength}
+}
+
+// document returns the sparse vector a document is stored with, every term weighted by its saturated frequency
+func (e bm25Encoder) document(text string) ([]uint32, []float32) {
+	terms := sparseTerms(text)
+	frequencies := make(map[uint32]float64)
+	for _, term := range terms {
+		frequencies[termIndex(term)]++
+	}
+	norm := bm25K1 * (1 - bm25B + bm25B*float64(len(terms))/e.averageLength)
+	weights := make(map[uint32]float64, len(frequencies))
+	for index, frequency := range frequencies {
+		weights[index] = frequency * (bm25K1 + 1) / (frequency + norm)
+	}
+	return sortedSparse(weights)
+}
+
+// query returns the sparse vector a search is run with, every distinct term weighted equally
+func (e bm25Encoder) query(text string) ([]uint32, []float32) {
+	weights := make(map[uint32]float64)
+	for _, term := range sparseTerms(text) {
+		weights[termIndex(term)] = 1
+	}
+	return sortedSparse(weights)
+}
+
+func sortedSparse(weights map[uint32]float64) ([]uint32, []float32) {
+	indices := make([]uint32, 0, len(weights))
+	for index := range weights {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	values := make([]float32, len(indices))
+	for i, index := range indices {
+		values[i] = float32(weights[index])
+	}
+	return indices, values
+}
+
+// fusion returns the method combining the dense and the sparse rankings
+func fusion(hybridConfig config.HybridConfig) (qdrant.Fusion, error) {
+	switch hybridConfig.Fusion {
+	case "", "rrf":
+		return qdrant.Fusion_RRF, nil
+	case "dbsf":
+		return qdrant.Fusion_DBSF, nil
+	default:
+		return 0, fmt.Errorf("fusion %s is not supported", hybridConfig.Fusion)
+	}
+}
+
+// pointVectors returns the vectors a point is stored with, the sparse vector of the text is added next to the dense
+// embedding when hybrid search is enabled
+func (q *QdrantConnector) pointVectors(text string, embedding []float32) *qdrant.Vectors {
+	if !q.config.Hybrid.Enabled {
+		return &qdrant.Vectors{VectorsOptions: &qdrant.Vectors_Vector{Vector: &qdrant.Vector{Data: embedding}}}
+	}
+	indices, values := q.sparse.document(text)
+	return qdrant.NewVectorsMap(map[string]*qdrant.Vector{
+		"":               qdrant.NewVectorDense(embedding),
+		sparseVectorName: qdrant.NewVectorSparse(indices, values),
+	})
+}
+
+// denseVector returns the unnamed dense vector of a point, which is returned alone or along with the sparse vector
+func denseVector(vectors *qdrant.VectorsOutput) []float32 {
+	vector := vectors.GetVector()
+	if vector == nil {
+		vector = vectors.GetVectors().GetVectors()[""]
+	}
+	if dense := vector.GetDense(); dense != nil {
+		return dense.GetData()
+	}
+	return vector.GetData()
+}
+
+// hybridSearch runs a dense and a sparse search for the reference point and fuses their rankings, skipping the first
+// skip points. The sparse query is computed from the text of the reference point, as the stored sparse vector carries
+// document weights
+func (q *QdrantConnector) hybridSearch(ctx context.Context, collection string, reference map[string]*qdrant.Value, vector []float32, filter *qdrant.Filter, count int, skip int) ([]*qdrant.ScoredPoint, error) {
+	text, err := data.EmbeddingText(reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the reference point: %w", err)
+	}
+	method, err := fusion(q.config.Hybrid)
+	if err != nil {
+		return nil, err
+	}
+	// the skipped points are ranked too, so the prefetches have to reach past them
+	prefetch := uint64(q.config.Hybrid.Prefetch)
+	if prefetch == 0 {
+		prefetch = uint64(3 * (count + skip))
+	}
+	limit := uint64(count)
+	offset := uint64(skip)
+	using := sparseVectorName
+	indices, values := q.sparse.query(text)
+
+	pointsClient := qdrant.NewPointsClient(q.grpcConnection)
+	queryResp, err := pointsClient.Query(ctx, &qdrant.QueryPoints{
+		CollectionName: collection,
+		Prefetch: []*qdrant.PrefetchQuery{
+			{Query: qdrant.NewQueryDense(vector), Filter: filter, Limit: &prefetch},
+			{Query: qdrant.NewQuerySparse(indices, values), Using: &using, Filter: filter, Limit: &prefetch},
+		},
+		Query:  qdrant.NewQueryFusion(method),
+		Filter: filter,
+		Limit:  &limit,
+		Offset: &offset,
+		WithPayload: &qdrant.WithPayloadSelector{
+			SelectorOptions: &qdrant.WithPayloadSelector_Enable{Enable: true},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return queryResp.GetResult(), nil
+}
@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/qdrant/go-client/qdrant"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSparseTerms(t *testing.T) {
+	terms := sparseTerms("Fix mm/page_alloc.c when CONFIG_DEBUG_VM=y, see v6.9-rc1.")
+	want := []string{
+		"fix",
+		"mm/page_alloc.c", "mm", "page_alloc", "c",
+		"when",
+		"config_debug_vm", "y",
+		"see",
+		"v6.9-rc1", "v6", "9", "rc1",
+	}
+	if !reflect.DeepEqual(terms, want) {
+		t.Errorf("terms %q, want %q", terms, want)
+	}
+}
+
+func TestBM25Encoder(t *testing.T) {
+	encoder := newBM25Encoder(4)
+	indices, values := encoder.document("lock the lock")
+	if len(indices) != 2 || !sort.SliceIsSorted(indices, func(i, j int) bool { return indices[i] < indices[j] }) {
+		t.Fatalf("indices %v, want two sorted indices", indices)
+	}
+	weights := make(map[uint32]float32)
+	for i, index := range indices {
+		weights[index] = values[i]
+	}
+	// the repeated term outweighs the single one, but saturates below twice its weight
+	lock, the := weights[termIndex("lock")], weights[termIndex("the")]
+	if lock <= the || lock >= 2*the {
+		t.Errorf("lock weighs %v and the %v", lock, the)
+	}
+
+	// a longer document weighs the same term frequency less
+	longerIndices, longerValues := encoder.document("the lock and some more words")
+	for i, index := range longerIndices {
+		if index == termIndex("the") && longerValues[i] >= the {
+			t.Errorf("the weighs %v in the longer document, want less than %v", longerValues[i], the)
+		}
+	}
+
+	queryIndices, queryValues := encoder.query("lock the lock")
+	if !reflect.DeepEqual(queryIndices, indices) || !reflect.DeepEqual(queryValues, []float32{1, 1}) {
+		t.Errorf("query vector %v %v, want the document indices weighted 1", queryIndices, queryValues)
+	}
+}
+
+func TestFusion(t *testing.T) {
+	tests := map[string]qdrant.Fusion{"": qdrant.Fusion_RRF, "rrf": qdrant.Fusion_RRF, "dbsf": qdrant.Fusion_DBSF}
+	for name, want := range tests {
+		got, err := fusion(config.HybridConfig{Fusion: name})
+		if err != nil || got != want {
+			t.Errorf("fusion(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := fusion(config.HybridConfig{Fusion: "sum"}); err == nil {
+		t.Error("unknown fusion was accepted")
+	}
+}
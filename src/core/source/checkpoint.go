@@ -0,0 +1,49 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// loadCheckpoint reads the checkpoint stored at path into v, reporting false if no checkpoint has been written yet
+func loadCheckpoint(path string, v any) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return false, fmt.Errorf("error decoding checkpoint: %w", err)
+	}
+	return true, nil
+}
+
+// saveCheckpoint writes v to path, going through a temporary file so that a crash never leaves a partial checkpoint
+func saveCheckpoint(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating checkpoint directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error replacing checkpoint: %w", err)
+	}
+	return nil
+}
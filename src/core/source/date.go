@@ -0,0 +1,131 @@
+package source
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dateComment matches the parenthesised comments that may trail or interrupt a date, e.g. "+0000 (UTC)"
+var dateComment = regexp.MustCompile(`\([^()]*\)`)
+
+// namedZones are the zone names of RFC 2822 along with the abbreviations commonly found in the wild. Both
+// time.Parse and mail.ParseDate accept any of them but silently treat the ones they do not know as UTC
+var namedZones = map[string]string{
+	"UT":   "+0000",
+	"UTC":  "+0000",
+	"GMT":  "+0000",
+	"Z":    "+0000",
+	"WET":  "+0000",
+	"BST":  "+0100",
+	"CET":  "+0100",
+	"MET":  "+0100",
+	"CEST": "+0200",
+	"MEST": "+0200",
+	"EET":  "+0200",
+	"EEST": "+0300",
+	"MSK":  "+0300",
+	"IST":  "+0530",
+	"JST":  "+0900",
+	"KST":  "+0900",
+	"AEST": "+1000",
+	"AEDT": "+1100",
+	"NZST": "+1200",
+	"NZDT": "+1300",
+	"EST":  "-0500",
+	"EDT":  "-0400",
+	"CST":  "-0600",
+	"CDT":  "-0500",
+	"MST":  "-0700",
+	"MDT":  "-0600",
+	"PST":  "-0800",
+	"PDT":  "-0700",
+}
+
+// dateLayouts are tried in order on a normalised date, which has no weekday, no comments and a numeric zone
+var dateLayouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04 -0700",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"Jan 2 15:04:05 2006 -0700",
+	"Jan 2 15:04:05 2006",
+	"Jan 2 2006 15:04:05 -0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// parseDate parses the Date header of a mail. Beyond RFC 5322 it accepts the variants mail clients actually
+// produce: a missing weekday, single digit days, two digit years, named zones, a missing zone (taken as UTC),
+// trailing comments and asctime dates as found on mbox "From " lines
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+	normalised := normaliseDate(value)
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, normalised); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unparsable date %q", value)
+}
+
+func normaliseDate(value string) string {
+	value = dateComment.ReplaceAllString(value, " ")
+	fields := strings.Fields(strings.ReplaceAll(value, ",", " "))
+	if len(fields) > 0 && isWeekday(fields[0]) {
+		fields = fields[1:]
+	}
+	for i, field := range fields {
+		if len(field) >= 3 && isMonth(field) {
+			fields[i] = strings.ToUpper(field[:1]) + strings.ToLower(field[1:3])
+			continue
+		}
+		if offset, ok := namedZones[strings.ToUpper(field)]; ok {
+			fields[i] = offset
+			continue
+		}
+		// "GMT+0200" and friends carry both a name and an offset
+		if upper := strings.ToUpper(field); len(upper) > 5 && (strings.HasPrefix(upper, "GMT") || strings.HasPrefix(upper, "UTC")) {
+			fields[i] = upper[3:]
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func isWeekday(field string) bool {
+	if len(field) < 3 {
+		return false
+	}
+	switch strings.ToLower(field[:3]) {
+	case "mon", "tue", "wed", "thu", "fri", "sat", "sun":
+		return true
+	default:
+		return false
+	}
+}
+
+func isMonth(field string) bool {
+	switch strings.ToLower(field) {
+	case "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "sept", "oct", "nov", "dec",
+		"january", "february", "march", "april", "june", "july", "august", "september", "october", "november", "december":
+		return true
+	default:
+		return false
+	}
+}
+
+// mboxEnvelopeDate parses the date of an mbox "From " line, e.g. "From jane@example.com Mon Jan  2 15:04:05 2006"
+func mboxEnvelopeDate(line string) (time.Time, error) {
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "From "))
+	if len(fields) < 2 {
+		return time.Time{}, fmt.Errorf("no date on the envelope line")
+	}
+	return parseDate(strings.Join(fields[1:], " "))
+}
@@ -0,0 +1,52 @@
+package source
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"Wed, 1 May 2024 12:30:15 +0200", time.Date(2024, 5, 1, 10, 30, 15, 0, time.UTC)},
+		{"1 May 2024 12:30:15 +0200 (CEST)", time.Date(2024, 5, 1, 10, 30, 15, 0, time.UTC)},
+		{"Wed,  01 may 24 12:30 -0000", time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)},
+		{"Wed, 1 May 2024 12:30:15 PDT", time.Date(2024, 5, 1, 19, 30, 15, 0, time.UTC)},
+		{"Wed, 1 May 2024 12:30:15 GMT+0200", time.Date(2024, 5, 1, 10, 30, 15, 0, time.UTC)},
+		{"Wednesday, 1 May 2024 12:30:15", time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC)},
+		{"Wed May  1 12:30:15 2024", time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC)},
+		{"2024-05-01T12:30:15+02:00", time.Date(2024, 5, 1, 10, 30, 15, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			date, err := parseDate(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !date.Equal(test.want) {
+				t.Errorf("parsed %v, want %v", date, test.want)
+			}
+		})
+	}
+
+	for _, value := range []string{"", "  ", "yesterday", "1 Foo 2024 12:30:15 +0200"} {
+		if date, err := parseDate(value); err == nil {
+			t.Errorf("parseDate(%q) = %v, want an error", value, date)
+		}
+	}
+}
+
+func TestMboxEnvelopeDate(t *testing.T) {
+	date, err := mboxEnvelopeDate("From jane@example.org Wed May  1 12:30:15 2024\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC); !date.Equal(want) {
+		t.Errorf("parsed %v, want %v", date, want)
+	}
+	if _, err := mboxEnvelopeDate("From jane@example.org\n"); err == nil {
+		t.Error("envelope line without a date was parsed")
+	}
+}
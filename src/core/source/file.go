@@ -0,0 +1,332 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	data2 "github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	fileFormatAuto    = "auto"
+	fileFormatMbox    = "mbox"
+	fileFormatMaildir = "maildir"
+)
+
+var mboxSeparator = []byte("From ")
+
+// FileConnector reads archived mail from mbox files and Maildir directories
+type FileConnector struct {
+	config     config.FileConfig
+	collection string
+	quarantine quarantine
+}
+
+// fileMetadata locates a single message, which is either a whole Maildir file or a byte range of an mbox file
+type fileMetadata struct {
+	path   string
+	mbox   bool
+	offset int64
+	length int64
+	// received is the date of the mbox "From " line or the modification time of a Maildir file, it stands in for
+	// a missing or unparsable Date header
+	received time.Time
+}
+
+func (m fileMetadata) String() string {
+	if m.mbox {
+		return m.path + "@" + strconv.FormatInt(m.offset, 10)
+	}
+	return m.path
+}
+
+func NewFileConnector(ctx context.Context, cfg config.FileConfig, collection string, quarantineStorage storage.Storage) (*FileConnector, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	switch cfg.Format {
+	case "":
+		cfg.Format = fileFormatAuto
+	case fileFormatAuto, fileFormatMbox, fileFormatMaildir:
+	default:
+		logger.Error("unsupported file format", slog.String("component", "Source"), slog.String("format", cfg.Format))
+		return nil, fmt.Errorf("file format %s is not supported", cfg.Format)
+	}
+	if _, err := os.Stat(cfg.Path); err != nil {
+		logger.Error("could not access file source path", slog.String("component", "Source"), slog.String("path", cfg.Path), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return &FileConnector{config: cfg, collection: collection, quarantine: quarantine{storage: quarantineStorage, collection: collection}}, nil
+}
+
+func (s *FileConnector) GetMetadata(ctx context.Context) ([]data2.Metadata, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	logger.Info("fetching metadata", slog.String("component", "Source"), slog.String("path", s.config.Path))
+	metadataList := make([]data2.Metadata, 0)
+	err := filepath.WalkDir(s.config.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			// the message directories of a Maildir are indexed together with the Maildir itself
+			if isMaildirSubdirectory(path) {
+				return filepath.SkipDir
+			}
+			if s.config.Format != fileFormatMbox && isMaildir(path) {
+				messages, err := indexMaildir(path)
+				if err != nil {
+					return err
+				}
+				metadataList = append(metadataList, messages...)
+			}
+			return nil
+		}
+
+		if !entry.Type().IsRegular() || s.config.Format == fileFormatMaildir {
+			return nil
+		}
+		if s.config.Format == fileFormatAuto {
+			mbox, err := looksLikeMbox(path)
+			if err != nil {
+				return err
+			}
+			if !mbox {
+				logger.Debug("skipping file that is not an mbox", slog.String("component", "Source"), slog.String("path", path))
+				return nil
+			}
+		}
+		messages, err := indexMbox(path)
+		if err != nil {
+			return err
+		}
+		metadataList = append(metadataList, messages...)
+		return nil
+	})
+	if err != nil {
+		logger.Error("could not index the mail archive", slog.String("component", "Source"), slog.String("path", s.config.Path), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	logger.Info("fetched metadata", slog.String("component", "Source"), slog.Int("count", len(metadataList)))
+	return metadataList, nil
+}
+
+func (s *FileConnector) GetData(ctx context.Context, metadataList []data2.Metadata) ([]data2.Data, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	logger.Info("ingestion of data from the metadata", slog.String("component", "Source"))
+	files := make(map[string]*os.File)
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+
+	dataList := make([]data2.Data, 0)
+	for _, metadataInterfaceComponent := range metadataList {
+		metadataComponent, ok := metadataInterfaceComponent.(fileMetadata)
+		if !ok {
+			logger.Error("could not cast metadata", slog.String("component", "Source"))
+			return nil, fmt.Errorf("metadata interface component is not of type fileMetadata")
+		}
+
+		var raw []byte
+		if metadataComponent.mbox {
+			file, ok := files[metadataComponent.path]
+			if !ok {
+				var err error
+				file, err = os.Open(metadataComponent.path)
+				if err != nil {
+					logger.Error("could not open mbox", slog.String("component", "Source"), slog.String("path", metadataComponent.path), slog.String("error", err.Error()))
+					return nil, err
+				}
+				files[metadataComponent.path] = file
+			}
+			raw = make([]byte, metadataComponent.length)
+			if _, err := file.ReadAt(raw, metadataComponent.offset); err != nil {
+				logger.Error("could not read message", slog.String("component", "Source"), slog.String("id", metadataComponent.String()), slog.String("error", err.Error()))
+				return nil, err
+			}
+			raw = unescapeMboxrd(raw)
+		} else {
+			var err error
+			raw, err = os.ReadFile(metadataComponent.path)
+			if err != nil {
+				logger.Error("could not read message", slog.String("component", "Source"), slog.String("id", metadataComponent.String()), slog.String("error", err.Error()))
+				return nil, err
+			}
+		}
+
+		parsed, err := parseMessage(raw)
+		if err != nil {
+			s.quarantine.put(ctx, metadataComponent.String(), raw, err)
+			continue
+		}
+		id := parsed.messageID
+		if id == "" {
+			// without a Message-ID the content is the only stable identity of a message
+			sum := sha256.Sum256(raw)
+			id = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		date := parsed.date
+		if date.IsZero() {
+			if metadataComponent.received.IsZero() {
+				s.quarantine.put(ctx, metadataComponent.String(), raw, parsed.dateErr)
+				continue
+			}
+			date = metadataComponent.received
+		}
+		dataList = append(dataList, mailOrPatch(data2.MailData{
+			Source:      "file",
+			Data:        parsed.body,
+			Metadata:    data2.MailMetadata{Id: id},
+			Sender:      parsed.sender,
+			Subject:     parsed.subject,
+			Date:        date,
+			List:        parsed.list,
+			MessageID:   parsed.messageID,
+			InReplyTo:   parsed.inReplyTo,
+			References:  parsed.references,
+			Attachments: parsed.attachments,
+		}))
+	}
+	return dataList, nil
+}
+
+func (s *FileConnector) GetCollection(ctx context.Context) string {
+	logger := ctx.Value("logger").(*slog.Logger)
+	logger.Info("fetching collection", slog.String("collection", s.collection))
+	return s.collection
+}
+
+// Checkpoint is a no-op as file archives are backfilled as a whole
+func (s *FileConnector) Checkpoint(ctx context.Context) error {
+	return nil
+}
+
+func isMaildir(path string) bool {
+	for _, name := range []string{"cur", "new"} {
+		info, err := os.Stat(filepath.Join(path, name))
+		if err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+func isMaildirSubdirectory(path string) bool {
+	switch filepath.Base(path) {
+	case "cur", "new", "tmp":
+		return isMaildir(filepath.Dir(path))
+	default:
+		return false
+	}
+}
+
+// indexMaildir lists the delivered messages of a Maildir, messages still in tmp are being delivered and are skipped
+func indexMaildir(path string) ([]data2.Metadata, error) {
+	metadataList := make([]data2.Metadata, 0)
+	for _, name := range []string{"cur", "new"} {
+		entries, err := os.ReadDir(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			metadataList = append(metadataList, fileMetadata{path: filepath.Join(path, name, entry.Name()), received: info.ModTime()})
+		}
+	}
+	return metadataList, nil
+}
+
+func looksLikeMbox(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	prefix := make([]byte, len(mboxSeparator))
+	if _, err := io.ReadFull(file, prefix); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(prefix, mboxSeparator), nil
+}
+
+// indexMbox finds the byte range of every message in an mbox, a message starts after a "From " line that follows a blank line
+func indexMbox(path string) ([]data2.Metadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	metadataList := make([]data2.Metadata, 0)
+	reader := bufio.NewReader(file)
+	offset := int64(0)
+	start := int64(-1)
+	var received time.Time
+	previousBlank := true
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if previousBlank && bytes.HasPrefix(line, mboxSeparator) {
+				if start >= 0 {
+					metadataList = append(metadataList, fileMetadata{path: path, mbox: true, offset: start, length: offset - start, received: received})
+				}
+				start = offset + int64(len(line))
+				// a malformed envelope line only loses the fallback date
+				received, _ = mboxEnvelopeDate(string(line))
+			}
+			previousBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if start >= 0 && offset > start {
+		metadataList = append(metadataList, fileMetadata{path: path, mbox: true, offset: start, length: offset - start, received: received})
+	}
+	return metadataList, nil
+}
+
+// unescapeMboxrd reverses the ">From " quoting that mbox writers apply to lines of the body
+func unescapeMboxrd(raw []byte) []byte {
+	lines := bytes.SplitAfter(raw, []byte("\n"))
+	for i, line := range lines {
+		quoted := bytes.TrimLeft(line, ">")
+		if len(quoted) < len(line) && bytes.HasPrefix(quoted, mboxSeparator) {
+			lines[i] = line[1:]
+		}
+	}
+	return bytes.Join(lines, nil)
+}
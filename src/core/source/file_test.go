@@ -0,0 +1,70 @@
+package source
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testMbox = `From jane@example.org Wed May  1 12:30:15 2024
+Subject: first
+
+the body quotes
+>From the start of a line
+and a line
+From within a paragraph is not a separator
+
+From john@example.org Thu May  2 08:00:00 2024
+Subject: second
+
+>>From a quoted quote
+`
+
+func TestIndexMbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.mbox")
+	if err := os.WriteFile(path, []byte(testMbox), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	metadataList, err := indexMbox(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadataList) != 2 {
+		t.Fatalf("indexed %d messages, want 2", len(metadataList))
+	}
+
+	want := []struct {
+		message  string
+		received time.Time
+	}{
+		{"Subject: first\n\nthe body quotes\nFrom the start of a line\nand a line\nFrom within a paragraph is not a separator\n\n",
+			time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC)},
+		{"Subject: second\n\n>From a quoted quote\n", time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)},
+	}
+	for i, metadata := range metadataList {
+		m := metadata.(fileMetadata)
+		message := string(unescapeMboxrd([]byte(testMbox[m.offset : m.offset+m.length])))
+		if message != want[i].message {
+			t.Errorf("message %d is %q, want %q", i, message, want[i].message)
+		}
+		if !m.received.Equal(want[i].received) {
+			t.Errorf("message %d was received %v, want %v", i, m.received, want[i].received)
+		}
+	}
+}
+
+func TestUnescapeMboxrd(t *testing.T) {
+	tests := map[string]string{
+		">From here\n":          "From here\n",
+		">>>From here\n":        ">>From here\n",
+		"> From here\n":         "> From here\n",
+		">Fromage\n":            ">Fromage\n",
+		"a\n>From b\r\n>From c": "a\nFrom b\r\nFrom c",
+	}
+	for escaped, want := range tests {
+		if got := string(unescapeMboxrd([]byte(escaped))); got != want {
+			t.Errorf("unescapeMboxrd(%q) = %q, want %q", escaped, got, want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package source
+
+import (
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// filterFields maps the shorthands of a filter rule to header names
+var filterFields = map[string]string{
+	"from":    "From",
+	"to":      "To",
+	"cc":      "Cc",
+	"subject": "Subject",
+	"listid":  "List-Id",
+}
+
+// gmailOperators are the Gmail search operators for the headers that have one
+var gmailOperators = map[string]string{
+	"From":    "from",
+	"To":      "to",
+	"Cc":      "cc",
+	"Subject": "subject",
+	"List-Id": "list",
+}
+
+// headerFilter is a compiled config.FilterRule
+type headerFilter struct {
+	all    []*headerFilter
+	any    []*headerFilter
+	not    *headerFilter
+	header string
+	match  func(value string) bool
+	// literal is the matched text of equals, which is what can be turned into a search query
+	literal string
+}
+
+func newHeaderFilter(rule config.FilterRule) (*headerFilter, error) {
+	filter := &headerFilter{}
+	for _, child := range rule.All {
+		compiled, err := newHeaderFilter(child)
+		if err != nil {
+			return nil, err
+		}
+		filter.all = append(filter.all, compiled)
+	}
+	for _, child := range rule.Any {
+		compiled, err := newHeaderFilter(child)
+		if err != nil {
+			return nil, err
+		}
+		filter.any = append(filter.any, compiled)
+	}
+	if rule.Not != nil {
+		compiled, err := newHeaderFilter(*rule.Not)
+		if err != nil {
+			return nil, err
+		}
+		filter.not = compiled
+	}
+	if rule.Field == "" {
+		return filter, nil
+	}
+
+	filter.header = textproto.CanonicalMIMEHeaderKey(rule.Field)
+	if header, ok := filterFields[strings.ToLower(rule.Field)]; ok {
+		filter.header = header
+	}
+	switch {
+	case rule.Regex != "":
+		pattern, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling filter regex %q: %w", rule.Regex, err)
+		}
+		filter.match = pattern.MatchString
+	case rule.Glob != "":
+		pattern, err := regexp.Compile("(?i)^" + globToRegex(rule.Glob) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("error compiling filter glob %q: %w", rule.Glob, err)
+		}
+		filter.match = pattern.MatchString
+	case rule.Equals != "":
+		filter.literal = rule.Equals
+		filter.match = func(value string) bool { return strings.EqualFold(strings.TrimSpace(value), rule.Equals) }
+	default:
+		contains := strings.ToLower(rule.Contains)
+		filter.match = func(value string) bool { return strings.Contains(strings.ToLower(value), contains) }
+	}
+	return filter, nil
+}
+
+func globToRegex(glob string) string {
+	var builder strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			builder.WriteString(".*")
+		case '?':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return builder.String()
+}
+
+// matches evaluates the filter against the headers of a mail. A field matches when any of its values does,
+// so a mail without the header never matches it
+func (f *headerFilter) matches(headers textproto.MIMEHeader) bool {
+	for _, child := range f.all {
+		if !child.matches(headers) {
+			return false
+		}
+	}
+	if len(f.any) > 0 {
+		matched := false
+		for _, child := range f.any {
+			if child.matches(headers) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.not != nil && f.not.matches(headers) {
+		return false
+	}
+	if f.match == nil {
+		return true
+	}
+	for _, value := range headers.Values(f.header) {
+		if f.match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// headers lists the headers the filter looks at, so that only those have to be fetched to evaluate it
+func (f *headerFilter) headers() []string {
+	seen := make(map[string]bool)
+	var collect func(*headerFilter)
+	collect = func(filter *headerFilter) {
+		if filter == nil {
+			return
+		}
+		if filter.header != "" {
+			seen[filter.header] = true
+		}
+		for _, child := range append(append([]*headerFilter{}, filter.all...), filter.any...) {
+			collect(child)
+		}
+		collect(filter.not)
+	}
+	collect(f)
+
+	headers := make([]string, 0, len(seen))
+	for header := range seen {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+// gmailQuery derives a Gmail search query that matches at least every mail the filter matches, it is empty when
+// nothing can be derived. Gmail matches the operators on whole words and addresses, so only equals is pushed down,
+// for the subject as a phrase and for the other headers when the value is a bare address or list id. Contains,
+// negations and regex or glob matches are left to matches, and an any is only pushed down when all of its
+// alternatives can be
+func (f *headerFilter) gmailQuery() string {
+	terms := make([]string, 0)
+	for _, child := range f.all {
+		if query := child.gmailQuery(); query != "" {
+			terms = append(terms, query)
+		}
+	}
+	if len(f.any) > 0 {
+		alternatives := make([]string, 0, len(f.any))
+		for _, child := range f.any {
+			query := child.gmailQuery()
+			if query == "" {
+				alternatives = nil
+				break
+			}
+			alternatives = append(alternatives, query)
+		}
+		if len(alternatives) > 0 {
+			terms = append(terms, "{"+strings.Join(alternatives, " ")+"}")
+		}
+	}
+	if operator, ok := gmailOperators[f.header]; ok && f.literal != "" {
+		if f.header == "Subject" {
+			terms = append(terms, operator+":"+quoteGmailTerm(f.literal))
+		} else if !strings.ContainsAny(f.literal, " \t\"<>(){},;") {
+			terms = append(terms, operator+":"+f.literal)
+		}
+	}
+	if len(terms) > 1 {
+		return "(" + strings.Join(terms, " ") + ")"
+	}
+	return strings.Join(terms, " ")
+}
+
+func quoteGmailTerm(term string) string {
+	if strings.ContainsAny(term, " \t\"(){}") {
+		return `"` + strings.ReplaceAll(term, `"`, "") + `"`
+	}
+	return term
+}
@@ -0,0 +1,90 @@
+package source
+
+import (
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"net/textproto"
+	"testing"
+)
+
+func TestHeaderFilterMatches(t *testing.T) {
+	headers := textproto.MIMEHeader{
+		"From":    {"Jane Doe <jane@example.org>"},
+		"Subject": {"[PATCH net] bugfix: keep the lock"},
+		"List-Id": {"<netdev.vger.kernel.org>"},
+	}
+	tests := []struct {
+		name string
+		rule config.FilterRule
+		want bool
+	}{
+		{"contains ignores case", config.FilterRule{Field: "subject", Contains: "FIX"}, true},
+		{"contains misses", config.FilterRule{Field: "subject", Contains: "revert"}, false},
+		{"equals trims", config.FilterRule{Field: "listID", Equals: "<NETDEV.vger.kernel.org>"}, true},
+		{"glob", config.FilterRule{Field: "from", Glob: "*@example.org>"}, true},
+		{"regex", config.FilterRule{Field: "subject", Regex: `^\[PATCH net\]`}, true},
+		{"missing header", config.FilterRule{Field: "cc", Contains: "jane"}, false},
+		{"not", config.FilterRule{Not: &config.FilterRule{Field: "subject", Contains: "patch"}}, false},
+		{"all", config.FilterRule{All: []config.FilterRule{
+			{Field: "from", Contains: "jane"},
+			{Field: "subject", Contains: "lock"},
+		}}, true},
+		{"any", config.FilterRule{Any: []config.FilterRule{
+			{Field: "from", Contains: "john"},
+			{Field: "listID", Contains: "netdev"},
+		}}, true},
+		{"empty", config.FilterRule{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := newHeaderFilter(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filter.matches(headers); got != test.want {
+				t.Errorf("matches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHeaderFilterGmailQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		rule config.FilterRule
+		want string
+	}{
+		// gmail matches whole words, so subject:fix would miss "bugfix: ..."
+		{"contains is not pushed down", config.FilterRule{Field: "subject", Contains: "fix"}, ""},
+		{"subject equals is a phrase", config.FilterRule{Field: "subject", Equals: "weekly report"}, `subject:"weekly report"`},
+		{"bare address", config.FilterRule{Field: "from", Equals: "jane@example.org"}, "from:jane@example.org"},
+		{"named address", config.FilterRule{Field: "from", Equals: "Jane Doe <jane@example.org>"}, ""},
+		{"bare list id", config.FilterRule{Field: "listID", Equals: "netdev.vger.kernel.org"}, "list:netdev.vger.kernel.org"},
+		{"header without operator", config.FilterRule{Field: "X-Mailer", Equals: "git-send-email"}, ""},
+		{"regex", config.FilterRule{Field: "subject", Regex: "^fix"}, ""},
+		{"not", config.FilterRule{Not: &config.FilterRule{Field: "from", Equals: "bot@example.org"}}, ""},
+		{"all keeps the pushable terms", config.FilterRule{All: []config.FilterRule{
+			{Field: "listID", Equals: "netdev.vger.kernel.org"},
+			{Field: "subject", Contains: "PATCH"},
+			{Field: "from", Equals: "jane@example.org"},
+		}}, "(list:netdev.vger.kernel.org from:jane@example.org)"},
+		{"any with a contains alternative", config.FilterRule{Any: []config.FilterRule{
+			{Field: "from", Equals: "jane@example.org"},
+			{Field: "subject", Contains: "fix"},
+		}}, ""},
+		{"any", config.FilterRule{Any: []config.FilterRule{
+			{Field: "from", Equals: "jane@example.org"},
+			{Field: "to", Equals: "john@example.org"},
+		}}, "{from:jane@example.org to:john@example.org}"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := newHeaderFilter(test.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filter.gmailQuery(); got != test.want {
+				t.Errorf("gmailQuery() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
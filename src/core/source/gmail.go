@@ -2,25 +2,39 @@ package source
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
 	data2 "github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"log/slog"
-	"strings"
+	"net/http"
+	"net/textproto"
 	"time"
 )
 
+const defaultGmailBackfill = "1d"
+
 type GmailConnector struct {
-	config     config.GmailConfig
-	client     *gmail.Service
-	collection string
+	config           config.GmailConfig
+	client           *gmail.Service
+	collection       string
+	filter           *headerFilter
+	quarantine       quarantine
+	pendingHistoryID uint64
+}
+
+type gmailCheckpoint struct {
+	HistoryID uint64 `json:"historyId"`
 }
 
-func NewGmailConnector(ctx context.Context, cfg config.GmailConfig, collection string) (*GmailConnector, error) {
+func NewGmailConnector(ctx context.Context, cfg config.GmailConfig, collection string, quarantineStorage storage.Storage) (*GmailConnector, error) {
 	logger := ctx.Value("logger").(*slog.Logger)
 
 	oauthConfig := &oauth2.Config{
@@ -45,10 +59,19 @@ func NewGmailConnector(ctx context.Context, cfg config.GmailConfig, collection s
 		return nil, err
 	}
 
+	filter, err := newHeaderFilter(cfg.Filters)
+	if err != nil {
+		logger.Error("invalid filter rule", slog.String("component", "Source"), slog.String("error", err.Error()))
+		return nil, err
+	}
+	logger.Info("created gmail source", slog.String("component", "Source"), slog.String("query", filter.gmailQuery()))
+
 	return &GmailConnector{
 		config:     cfg,
 		client:     svc,
 		collection: collection,
+		filter:     filter,
+		quarantine: quarantine{storage: quarantineStorage, collection: collection},
 	}, nil
 }
 
@@ -56,21 +79,93 @@ func (s *GmailConnector) GetMetadata(ctx context.Context) ([]data2.Metadata, err
 	logger := ctx.Value("logger").(*slog.Logger)
 
 	user := "me"
-	// TODO: make the duration configurable
 	logger.Info("fetching metadata", slog.String("user", user))
-	request := s.client.Users.Messages.List(user).Q("newer_than:1d")
-	response, err := request.Do()
+
+	// the profile history ID is taken before listing so that nothing added while listing is missed on the next run
+	profile, err := s.client.Users.GetProfile(user).Context(ctx).Do()
+	if err != nil {
+		logger.Error("could not get the profile", slog.String("component", "Source"), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	var checkpoint gmailCheckpoint
+	found, err := loadCheckpoint(s.config.Checkpoint, &checkpoint)
+	if err != nil {
+		logger.Error("could not load the checkpoint", slog.String("component", "Source"), slog.String("path", s.config.Checkpoint), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	var metadataList []data2.Metadata
+	if found && checkpoint.HistoryID != 0 {
+		metadataList, err = s.listHistory(ctx, user, checkpoint.HistoryID)
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			// the history ID is too old to be served, so fall back to the backfill window
+			logger.Warn("history checkpoint expired, backfilling", slog.String("component", "Source"), slog.Uint64("historyId", checkpoint.HistoryID))
+			metadataList, err = s.listMessages(ctx, user)
+		}
+	} else {
+		metadataList, err = s.listMessages(ctx, user)
+	}
 	if err != nil {
-		logger.Error("could not list users", slog.String("component", "Source"), slog.String("error", err.Error()))
+		logger.Error("could not list messages", slog.String("component", "Source"), slog.String("error", err.Error()))
 		return nil, err
 	}
 
+	s.pendingHistoryID = profile.HistoryId
+	logger.Info("fetched metadata", slog.String("component", "Source"), slog.Int("count", len(metadataList)))
+	return metadataList, nil
+}
+
+// listMessages pages through all the messages in the backfill window, narrowed down by the part of the filter rule
+// that Gmail can evaluate itself
+func (s *GmailConnector) listMessages(ctx context.Context, user string) ([]data2.Metadata, error) {
+	backfill := s.config.Backfill
+	if backfill == "" {
+		backfill = defaultGmailBackfill
+	}
+	query := "newer_than:" + backfill
+	if filterQuery := s.filter.gmailQuery(); filterQuery != "" {
+		query += " " + filterQuery
+	}
+
 	metadataList := make([]data2.Metadata, 0)
-	for _, message := range response.Messages {
-		metadataList = append(metadataList, data2.MailMetadata{
-			Id:       message.Id,
-			ThreadID: message.ThreadId,
-		})
+	err := s.client.Users.Messages.List(user).Q(query).Pages(ctx, func(response *gmail.ListMessagesResponse) error {
+		for _, message := range response.Messages {
+			metadataList = append(metadataList, data2.MailMetadata{
+				Id:       message.Id,
+				ThreadID: message.ThreadId,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metadataList, nil
+}
+
+// listHistory pages through all the messages added to the mailbox since the given history ID
+func (s *GmailConnector) listHistory(ctx context.Context, user string, historyID uint64) ([]data2.Metadata, error) {
+	metadataList := make([]data2.Metadata, 0)
+	seen := make(map[string]bool)
+	err := s.client.Users.History.List(user).StartHistoryId(historyID).HistoryTypes("messageAdded").Pages(ctx, func(response *gmail.ListHistoryResponse) error {
+		for _, history := range response.History {
+			for _, added := range history.MessagesAdded {
+				if added.Message == nil || seen[added.Message.Id] {
+					continue
+				}
+				seen[added.Message.Id] = true
+				metadataList = append(metadataList, data2.MailMetadata{
+					Id:       added.Message.Id,
+					ThreadID: added.Message.ThreadId,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return metadataList, nil
 }
@@ -87,6 +182,17 @@ func (s *GmailConnector) GetData(ctx context.Context, metadataList []data2.Metad
 			logger.Error("could not cast metadata", slog.String("component", "Source"))
 			return nil, fmt.Errorf("mailMetadata interface component is not of type Metadata")
 		}
+		// the history API cannot be queried, so the filter is evaluated on the headers alone before fetching the body
+		if headerNames := s.filter.headers(); len(headerNames) > 0 {
+			response, err := s.client.Users.Messages.Get(user, metadataComponent.Id).Format("metadata").MetadataHeaders(headerNames...).Do()
+			if err != nil {
+				return nil, err
+			}
+			if !s.filter.matches(gmailHeaders(response.Payload)) {
+				logger.Debug("skipping the mail, it does not match the filter", slog.String("component", "Source"), slog.String("id", metadataComponent.Id))
+				continue
+			}
+		}
 		logger.Info("fetching the mail", slog.String("id", metadataComponent.Id))
 		request := s.client.Users.Messages.Get(user, metadataComponent.Id).Format("full")
 		response, err := request.Do()
@@ -94,45 +200,102 @@ func (s *GmailConnector) GetData(ctx context.Context, metadataList []data2.Metad
 			return nil, err
 		}
 		sender := ""
-		date := time.Time{}
-		belongsToMailingList := false
+		var dateHeader, messageID, inReplyTo, list, subject string
+		var references []string
 		for _, header := range response.Payload.Headers {
-			if (header.Name == "X-Mailing-List") && (strings.Contains(header.Value, s.config.Filters)) {
-				belongsToMailingList = true
-			}
-
-			switch header.Name {
+			switch textproto.CanonicalMIMEHeaderKey(header.Name) {
 			case "Sender":
 				sender = header.Value
+			case "Message-Id":
+				messageID = firstMessageID(header.Value)
+			case "In-Reply-To":
+				inReplyTo = firstMessageID(header.Value)
+			case "References":
+				references = parseMessageIDs(header.Value)
+			case "List-Id":
+				list = listName(header.Value)
+			case "Subject":
+				subject = header.Value
 			case "Date":
-				dateStr := header.Value
-				if len(dateStr) >= 31 {
-					dateStr = dateStr[:31] // Keep only "Sun, 15 Jun 2025 13:49:35"
-				}
-				layout := "Mon, 02 Jan 2006 15:04:05 -0700" // matches the format
-				date, err = time.Parse(layout, dateStr)
-				if err != nil {
-					logger.Error("could not parse date", slog.String("component", "Source"), slog.String("error", err.Error()), slog.String("date", dateStr))
-					// TODO: Find a dump or a solution for unparsable dates
-					return nil, err
-				}
+				dateHeader = header.Value
 			}
 		}
-		if !belongsToMailingList {
-			continue
+		date, err := parseDate(dateHeader)
+		if err != nil {
+			if response.InternalDate <= 0 {
+				s.quarantineMessage(ctx, user, metadataComponent.Id, err)
+				continue
+			}
+			// the internal date is when Gmail received the message, which is close enough for ordering
+			logger.Debug("falling back to the internal date", slog.String("component", "Source"), slog.String("id", metadataComponent.Id), slog.String("error", err.Error()))
+			date = time.UnixMilli(response.InternalDate)
 		}
-		body, err := extractMessageBody(response.Payload)
+		body, attachments, err := extractMessageBody(response.Payload)
 		if err != nil {
-			logger.Error("could not extract message body", slog.String("component", "Source"), slog.String("error", err.Error()), slog.String("id", metadataComponent.Id))
-			return nil, err
+			s.quarantineMessage(ctx, user, metadataComponent.Id, fmt.Errorf("error extracting message body: %w", err))
+			continue
 		}
-		dataList = append(dataList, data2.MailData{Data: body, Metadata: metadataComponent, Sender: sender, Date: date})
+		dataList = append(dataList, mailOrPatch(data2.MailData{
+			Source:      "gmail",
+			Data:        body,
+			Metadata:    metadataComponent,
+			Sender:      sender,
+			Subject:     subject,
+			Date:        date,
+			List:        list,
+			MessageID:   messageID,
+			InReplyTo:   inReplyTo,
+			References:  references,
+			Attachments: attachments,
+		}))
 	}
 	return dataList, nil
 }
 
+// quarantineMessage fetches the message in its raw form, which is what is worth keeping for inspection
+func (s *GmailConnector) quarantineMessage(ctx context.Context, user string, id string, reason error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	var raw []byte
+	response, err := s.client.Users.Messages.Get(user, id).Format("raw").Do()
+	if err == nil {
+		raw, err = base64.URLEncoding.DecodeString(response.Raw)
+	}
+	if err != nil {
+		logger.Error("could not fetch the raw message", slog.String("component", "Source"), slog.String("id", id), slog.String("error", err.Error()))
+	}
+	s.quarantine.put(ctx, id, raw, reason)
+}
+
+// gmailHeaders collects the headers of a message part so that they can be matched like the headers of a parsed mail
+func gmailHeaders(part *gmail.MessagePart) textproto.MIMEHeader {
+	headers := make(textproto.MIMEHeader)
+	if part == nil {
+		return headers
+	}
+	for _, header := range part.Headers {
+		headers.Add(header.Name, header.Value)
+	}
+	return headers
+}
+
 func (s *GmailConnector) GetCollection(ctx context.Context) string {
 	logger := ctx.Value("logger").(*slog.Logger)
 	logger.Info("fetching collection", slog.String("collection", s.collection))
 	return s.collection
 }
+
+func (s *GmailConnector) Checkpoint(ctx context.Context) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if s.pendingHistoryID == 0 {
+		return nil
+	}
+	err := saveCheckpoint(s.config.Checkpoint, gmailCheckpoint{HistoryID: s.pendingHistoryID})
+	if err != nil {
+		logger.Error("could not save the checkpoint", slog.String("component", "Source"), slog.String("path", s.config.Checkpoint), slog.String("error", err.Error()))
+		return err
+	}
+	logger.Info("saved the checkpoint", slog.String("component", "Source"), slog.Uint64("historyId", s.pendingHistoryID))
+	return nil
+}
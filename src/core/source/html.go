@@ -0,0 +1,76 @@
+package source
+
+import (
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"strings"
+)
+
+// textWriter accumulates rendered text while tracking how it ends, so that block elements do not stack up blank lines
+type textWriter struct {
+	builder  strings.Builder
+	newlines int
+}
+
+func (w *textWriter) text(text string) {
+	if w.builder.Len() > 0 && w.newlines == 0 {
+		w.builder.WriteByte(' ')
+	}
+	w.builder.WriteString(text)
+	w.newlines = 0
+}
+
+func (w *textWriter) lineBreak(count int) {
+	for ; w.builder.Len() > 0 && w.newlines < count; w.newlines++ {
+		w.builder.WriteByte('\n')
+	}
+}
+
+// htmlToText renders an HTML mail body as readable text, keeping the block structure as line breaks and
+// dropping everything that is not displayed
+func htmlToText(document string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	var writer textWriter
+	skipDepth := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(writer.builder.String())
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			if text := strings.Join(strings.Fields(string(tokenizer.Text())), " "); text != "" {
+				writer.text(text)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style, atom.Head, atom.Title:
+				skipDepth++
+			case atom.Br:
+				writer.builder.WriteByte('\n')
+				writer.newlines++
+			case atom.P, atom.Div, atom.Table, atom.Blockquote, atom.Pre, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Ul, atom.Ol, atom.Hr:
+				writer.lineBreak(2)
+			case atom.Tr:
+				writer.lineBreak(1)
+			case atom.Li:
+				writer.lineBreak(1)
+				writer.text("-")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch atom.Lookup(name) {
+			case atom.Script, atom.Style, atom.Head, atom.Title:
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			case atom.P, atom.Div, atom.Table, atom.Blockquote, atom.Pre, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Ul, atom.Ol:
+				writer.lineBreak(2)
+			case atom.Tr, atom.Li:
+				writer.lineBreak(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package source
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	data2 "github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultImapFolder = "INBOX"
+
+type ImapConnector struct {
+	config     config.ImapConfig
+	collection string
+	quarantine quarantine
+
+	// the IMAP connection is stateful (selected folder), so every command goes through the lock
+	mutex   sync.Mutex
+	client  *client.Client
+	pending imapCheckpoint
+}
+
+// imapMetadata identifies a message by its UID within a folder, it is only used between GetMetadata and GetData
+type imapMetadata struct {
+	folder string
+	uid    uint32
+}
+
+type imapFolderCheckpoint struct {
+	UIDValidity uint32 `json:"uidValidity"`
+	LastUID     uint32 `json:"lastUid"`
+}
+
+type imapCheckpoint map[string]imapFolderCheckpoint
+
+func (m imapMetadata) String() string {
+	return m.folder + "/" + strconv.FormatUint(uint64(m.uid), 10)
+}
+
+func NewImapConnector(ctx context.Context, cfg config.ImapConfig, collection string, quarantineStorage storage.Storage) (*ImapConnector, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if len(cfg.Folders) == 0 {
+		cfg.Folders = []string{defaultImapFolder}
+	}
+	connector := &ImapConnector{config: cfg, collection: collection, quarantine: quarantine{storage: quarantineStorage, collection: collection}}
+	if err := connector.connect(ctx); err != nil {
+		logger.Error("could not create imap source", slog.String("component", "Source"), slog.String("error", err.Error()))
+		return nil, err
+	}
+	return connector, nil
+}
+
+// connect (re)establishes the IMAP session, the caller has to hold the lock
+func (s *ImapConnector) connect(ctx context.Context) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if s.client != nil && s.client.State() != imap.LogoutState {
+		return nil
+	}
+
+	address := s.config.Host + ":" + s.config.Port
+	logger.Info("connecting to imap server", slog.String("component", "Source"), slog.String("address", address))
+	var c *client.Client
+	var err error
+	switch s.config.Security {
+	case "", "tls":
+		c, err = client.DialTLS(address, &tls.Config{ServerName: s.config.Host})
+	case "starttls":
+		c, err = client.Dial(address)
+		if err == nil {
+			err = c.StartTLS(&tls.Config{ServerName: s.config.Host})
+		}
+	case "none":
+		c, err = client.Dial(address)
+	default:
+		return fmt.Errorf("unsupported imap security %s", s.config.Security)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to imap server: %w", err)
+	}
+	if err := c.Login(s.config.Username, s.config.Password); err != nil {
+		_ = c.Logout()
+		return fmt.Errorf("error logging in to imap server: %w", err)
+	}
+	s.client = c
+	return nil
+}
+
+func (s *ImapConnector) GetMetadata(ctx context.Context) ([]data2.Metadata, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.connect(ctx); err != nil {
+		logger.Error("could not connect to imap server", slog.String("component", "Source"), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	checkpoint := make(imapCheckpoint)
+	if _, err := loadCheckpoint(s.config.Checkpoint, &checkpoint); err != nil {
+		logger.Error("could not load the checkpoint", slog.String("component", "Source"), slog.String("path", s.config.Checkpoint), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	pending := make(imapCheckpoint)
+	metadataList := make([]data2.Metadata, 0)
+	for _, folder := range s.config.Folders {
+		logger.Info("fetching metadata", slog.String("component", "Source"), slog.String("folder", folder))
+		mailbox, err := s.client.Select(folder, true)
+		if err != nil {
+			logger.Error("could not select folder", slog.String("component", "Source"), slog.String("folder", folder), slog.String("error", err.Error()))
+			return nil, err
+		}
+
+		criteria, err := s.searchCriteria()
+		if err != nil {
+			logger.Error("invalid search criteria", slog.String("component", "Source"), slog.String("error", err.Error()))
+			return nil, err
+		}
+		// UIDs are only comparable while the UID validity of the folder stays the same
+		previous, ok := checkpoint[folder]
+		lastUID := uint32(0)
+		if ok && previous.UIDValidity == mailbox.UidValidity {
+			lastUID = previous.LastUID
+			criteria.Uid = new(imap.SeqSet)
+			criteria.Uid.AddRange(lastUID+1, 0)
+		}
+
+		uids, err := s.client.UidSearch(criteria)
+		if err != nil {
+			logger.Error("could not search folder", slog.String("component", "Source"), slog.String("folder", folder), slog.String("error", err.Error()))
+			return nil, err
+		}
+
+		folderCheckpoint := imapFolderCheckpoint{UIDValidity: mailbox.UidValidity, LastUID: lastUID}
+		for _, uid := range uids {
+			// "n:*" always matches the highest UID, even when it is lower than n
+			if uid <= lastUID {
+				continue
+			}
+			metadataList = append(metadataList, imapMetadata{folder: folder, uid: uid})
+			if uid > folderCheckpoint.LastUID {
+				folderCheckpoint.LastUID = uid
+			}
+		}
+		pending[folder] = folderCheckpoint
+	}
+
+	s.pending = pending
+	logger.Info("fetched metadata", slog.String("component", "Source"), slog.Int("count", len(metadataList)))
+	return metadataList, nil
+}
+
+// searchCriteria translates the configured search into the IMAP SEARCH criteria
+func (s *ImapConnector) searchCriteria() (*imap.SearchCriteria, error) {
+	search := s.config.Search
+	criteria := imap.NewSearchCriteria()
+	if search.Since != "" {
+		if duration, err := time.ParseDuration(search.Since); err == nil {
+			criteria.Since = time.Now().Add(-duration)
+		} else if date, err := time.Parse(time.DateOnly, search.Since); err == nil {
+			criteria.Since = date
+		} else {
+			return nil, fmt.Errorf("could not parse since %q", search.Since)
+		}
+	}
+	for _, from := range search.From {
+		criteria.Header.Add("From", from)
+	}
+	for _, to := range search.To {
+		criteria.Header.Add("To", to)
+	}
+	for _, subject := range search.Subject {
+		criteria.Header.Add("Subject", subject)
+	}
+	for name, value := range search.Header {
+		criteria.Header.Add(name, value)
+	}
+	criteria.Text = search.Text
+	if search.Unseen {
+		criteria.WithoutFlags = []string{imap.SeenFlag}
+	}
+	return criteria, nil
+}
+
+func (s *ImapConnector) GetData(ctx context.Context, metadataList []data2.Metadata) ([]data2.Data, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	logger.Info("ingestion of data from the metadata", slog.String("component", "Source"))
+	folders := make(map[string][]uint32)
+	order := make([]string, 0)
+	for _, metadataInterfaceComponent := range metadataList {
+		metadataComponent, ok := metadataInterfaceComponent.(imapMetadata)
+		if !ok {
+			logger.Error("could not cast metadata", slog.String("component", "Source"))
+			return nil, fmt.Errorf("metadata interface component is not of type imapMetadata")
+		}
+		if _, ok := folders[metadataComponent.folder]; !ok {
+			order = append(order, metadataComponent.folder)
+		}
+		folders[metadataComponent.folder] = append(folders[metadataComponent.folder], metadataComponent.uid)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.connect(ctx); err != nil {
+		logger.Error("could not connect to imap server", slog.String("component", "Source"), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	dataList := make([]data2.Data, 0)
+	for _, folder := range order {
+		mailbox, err := s.client.Select(folder, true)
+		if err != nil {
+			logger.Error("could not select folder", slog.String("component", "Source"), slog.String("folder", folder), slog.String("error", err.Error()))
+			return nil, err
+		}
+
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(folders[folder]...)
+		section := &imap.BodySectionName{Peek: true}
+		messages := make(chan *imap.Message, len(folders[folder]))
+		done := make(chan error, 1)
+		go func() {
+			done <- s.client.UidFetch(seqSet, []imap.FetchItem{imap.FetchUid, imap.FetchInternalDate, section.FetchItem()}, messages)
+		}()
+
+		for message := range messages {
+			body := message.GetBody(section)
+			if body == nil {
+				logger.Warn("server did not return the message body", slog.String("component", "Source"), slog.String("folder", folder), slog.Uint64("uid", uint64(message.Uid)))
+				continue
+			}
+			raw, err := io.ReadAll(body)
+			if err != nil {
+				logger.Error("could not read message", slog.String("component", "Source"), slog.String("folder", folder), slog.Uint64("uid", uint64(message.Uid)), slog.String("error", err.Error()))
+				continue
+			}
+			parsed, err := parseMessage(raw)
+			if err != nil {
+				s.quarantine.put(ctx, fmt.Sprintf("%s/%d/%d", folder, mailbox.UidValidity, message.Uid), raw, err)
+				continue
+			}
+
+			id := parsed.messageID
+			if id == "" {
+				id = fmt.Sprintf("%s/%d/%d", folder, mailbox.UidValidity, message.Uid)
+			}
+			date := parsed.date
+			if date.IsZero() {
+				if message.InternalDate.IsZero() {
+					s.quarantine.put(ctx, id, raw, parsed.dateErr)
+					continue
+				}
+				date = message.InternalDate
+			}
+			dataList = append(dataList, mailOrPatch(data2.MailData{
+				Source:      "imap",
+				Data:        parsed.body,
+				Metadata:    data2.MailMetadata{Id: id},
+				Sender:      parsed.sender,
+				Subject:     parsed.subject,
+				Date:        date,
+				List:        parsed.list,
+				MessageID:   parsed.messageID,
+				InReplyTo:   parsed.inReplyTo,
+				References:  parsed.references,
+				Attachments: parsed.attachments,
+			}))
+		}
+		if err := <-done; err != nil {
+			logger.Error("could not fetch messages", slog.String("component", "Source"), slog.String("folder", folder), slog.String("error", err.Error()))
+			return nil, err
+		}
+	}
+	return dataList, nil
+}
+
+func (s *ImapConnector) GetCollection(ctx context.Context) string {
+	logger := ctx.Value("logger").(*slog.Logger)
+	logger.Info("fetching collection", slog.String("collection", s.collection))
+	return s.collection
+}
+
+func (s *ImapConnector) Checkpoint(ctx context.Context) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if err := saveCheckpoint(s.config.Checkpoint, s.pending); err != nil {
+		logger.Error("could not save the checkpoint", slog.String("component", "Source"), slog.String("path", s.config.Checkpoint), slog.String("error", err.Error()))
+		return err
+	}
+	logger.Info("saved the checkpoint", slog.String("component", "Source"), slog.String("path", s.config.Checkpoint))
+	return nil
+}
@@ -0,0 +1,256 @@
+package source
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/text/encoding/htmlindex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// parsedMessage is the part of an RFC 5322 message that the mail sources care about
+type parsedMessage struct {
+	header     mail.Header
+	messageID  string
+	inReplyTo  string
+	references []string
+	list       string
+	subject    string
+	sender     string
+	date       time.Time
+	// dateErr is why date is zero, the sources fall back to a date of their own then
+	dateErr     error
+	body        string
+	attachments []string
+}
+
+// parseMessage parses a raw RFC 5322 message and extracts its body, preferring text/plain parts over HTML ones
+func parseMessage(raw []byte) (*parsedMessage, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("error reading message: %w", err)
+	}
+
+	parsed := &parsedMessage{
+		header:     message.Header,
+		messageID:  firstMessageID(message.Header.Get("Message-ID")),
+		inReplyTo:  firstMessageID(message.Header.Get("In-Reply-To")),
+		references: parseMessageIDs(message.Header.Get("References")),
+		list:       listName(message.Header.Get("List-Id")),
+		subject:    decodeHeader(message.Header.Get("Subject")),
+		sender:     decodeHeader(message.Header.Get("From")),
+	}
+	parsed.date, parsed.dateErr = parseDate(message.Header.Get("Date"))
+
+	var body messageBody
+	err = body.walk(textproto.MIMEHeader(message.Header), message.Body)
+	if err != nil {
+		return nil, err
+	}
+	text, err := body.text()
+	if err != nil {
+		return nil, err
+	}
+	parsed.body = text
+	parsed.attachments = body.attachments
+	return parsed, nil
+}
+
+// messageBody collects the text alternatives and the attachment names found while walking a MIME tree
+type messageBody struct {
+	plain       string
+	html        string
+	attachments []string
+}
+
+// text is the plain text alternative of the body, or the HTML one converted to text when that is all there is
+func (b *messageBody) text() (string, error) {
+	if b.plain != "" {
+		return b.plain, nil
+	}
+	if b.html != "" {
+		return htmlToText(b.html), nil
+	}
+	return "", fmt.Errorf("no body found")
+}
+
+// plainTypes are the media types read as plain text. Patches sent inline by git format-patch --inline are text/x-patch
+// or text/x-diff parts following the commit message
+var plainTypes = map[string]bool{
+	"text/plain":   true,
+	"text/x-patch": true,
+	"text/x-diff":  true,
+}
+
+// isText reports whether a part of the media type is read into the body
+func isText(mediaType string) bool {
+	return plainTypes[mediaType] || mediaType == "text/html"
+}
+
+// addText joins the plain text parts, which follow one another in a mixed message, and keeps the first HTML part
+func (b *messageBody) addText(mediaType string, text string) {
+	switch {
+	case plainTypes[mediaType]:
+		if b.plain == "" {
+			b.plain = text
+		} else if text != "" {
+			b.plain = strings.TrimRight(b.plain, "\n") + "\n\n" + text
+		}
+	case mediaType == "text/html":
+		if b.html == "" {
+			b.html = text
+		}
+	}
+}
+
+// walk visits a MIME part and its children, decoding text parts from their transfer encoding and charset
+func (b *messageBody) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// messages without a (valid) content type are plain text as per RFC 2045
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+
+	if name := attachmentName(header.Get("Content-Disposition"), mediaType, params); name != "" {
+		b.attachments = append(b.attachments, name)
+		return nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error reading multipart body: %w", err)
+			}
+			if err := b.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !isText(mediaType) {
+		return nil
+	}
+	decoded, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("error decoding body: %w", err)
+	}
+	b.addText(mediaType, decodeCharset(params["charset"], decoded))
+	return nil
+}
+
+// attachmentName is the file name of a part that is an attachment, or empty for parts that are shown inline. A named
+// text part is only an attachment when its disposition says so, as git send-email names the inline patches and some
+// clients name the only part of a message
+func attachmentName(contentDisposition string, mediaType string, contentTypeParams map[string]string) string {
+	disposition, params, err := mime.ParseMediaType(contentDisposition)
+	if err != nil {
+		disposition, params = "", map[string]string{}
+	}
+	if disposition != "attachment" && (isText(mediaType) || strings.HasPrefix(mediaType, "multipart/")) {
+		return ""
+	}
+	name := params["filename"]
+	if name == "" {
+		name = contentTypeParams["name"]
+	}
+	if name == "" && disposition == "attachment" {
+		name = "unnamed"
+	}
+	return decodeHeader(name)
+}
+
+// decodeCharset converts text in the given charset to UTF-8, leaving it untouched if the charset is unknown
+func decodeCharset(charset string, text []byte) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return string(text)
+	}
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(text)
+	}
+	decoded, err := encoding.NewDecoder().Bytes(text)
+	if err != nil {
+		return string(text)
+	}
+	return string(decoded)
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+// decodeHeader decodes RFC 2047 encoded words in a header value
+func decodeHeader(value string) string {
+	decoder := mime.WordDecoder{CharsetReader: charsetReader}
+	decoded, err := decoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// parseMessageIDs returns the message IDs of a Message-ID, In-Reply-To or References header without the angle brackets
+func parseMessageIDs(value string) []string {
+	ids := make([]string, 0)
+	for {
+		start := strings.IndexByte(value, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(value[start:], '>')
+		if end < 0 {
+			break
+		}
+		if id := strings.TrimSpace(value[start+1 : start+end]); id != "" {
+			ids = append(ids, id)
+		}
+		value = value[start+end+1:]
+	}
+	return ids
+}
+
+// firstMessageID is the single message ID of a header, tolerating headers that omit the angle brackets
+func firstMessageID(value string) string {
+	if ids := parseMessageIDs(value); len(ids) > 0 {
+		return ids[0]
+	}
+	return strings.TrimSpace(value)
+}
+
+// listName extracts the list identifier of a List-Id header, e.g. "linux-kernel.vger.kernel.org"
+func listName(value string) string {
+	if ids := parseMessageIDs(value); len(ids) > 0 {
+		return ids[0]
+	}
+	return strings.TrimSpace(value)
+}
+
+func decodeTransferEncoding(transferEncoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	default:
+		return body
+	}
+}
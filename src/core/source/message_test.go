@@ -0,0 +1,115 @@
+package source
+
+import (
+	"encoding/base64"
+	"google.golang.org/api/gmail/v1"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testMessage(contentType string, body string) []byte {
+	return []byte(strings.ReplaceAll("From: Jane Doe <jane@example.org>\n"+
+		"Subject: [PATCH] mm: free the buffer\n"+
+		"Date: Wed, 1 May 2024 12:30:15 +0200\n"+
+		"Message-ID: <patch@example.org>\n"+
+		"MIME-Version: 1.0\n"+
+		"Content-Type: "+contentType+"\n"+
+		"\n"+body, "\n", "\r\n"))
+}
+
+func TestParseMessageBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         []byte
+		body        string
+		attachments []string
+	}{
+		{"single named part", testMessage(`text/plain; charset="utf-8"; name="0001-mm.patch"`, "the patch\n"),
+			"the patch\r\n", nil},
+		{"inline patch", testMessage(`multipart/mixed; boundary="b"`, "--b\n"+
+			"Content-Type: text/plain; charset=utf-8\n"+
+			"\n"+
+			"Free the buffer on the error path.\n"+
+			"--b\n"+
+			"Content-Type: text/x-patch; name=\"0001-mm.patch\"\n"+
+			"Content-Disposition: inline; filename=\"0001-mm.patch\"\n"+
+			"\n"+
+			"diff --git a/mm/page_alloc.c b/mm/page_alloc.c\n"+
+			"--b--\n"),
+			"Free the buffer on the error path.\n\ndiff --git a/mm/page_alloc.c b/mm/page_alloc.c", nil},
+		{"text attachment", testMessage(`multipart/mixed; boundary="b"`, "--b\n"+
+			"Content-Type: text/plain\n"+
+			"\n"+
+			"See the log.\n"+
+			"--b\n"+
+			"Content-Type: text/plain; name=\"dmesg.txt\"\n"+
+			"Content-Disposition: attachment; filename=\"dmesg.txt\"\n"+
+			"\n"+
+			"[    0.000000] Linux version 6.9\n"+
+			"--b\n"+
+			"Content-Type: application/octet-stream\n"+
+			"Content-Disposition: attachment\n"+
+			"\n"+
+			"binary\n"+
+			"--b--\n"),
+			"See the log.", []string{"dmesg.txt", "unnamed"}},
+		{"html only", testMessage(`multipart/alternative; boundary="b"`, "--b\n"+
+			"Content-Type: text/html; charset=utf-8\n"+
+			"Content-Transfer-Encoding: quoted-printable\n"+
+			"\n"+
+			"<p>Looks good to me=2E</p>\n"+
+			"--b--\n"),
+			"Looks good to me.", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := parseMessage(test.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(strings.ReplaceAll(parsed.body, "\r\n", "\n")) != strings.TrimSpace(strings.ReplaceAll(test.body, "\r\n", "\n")) {
+				t.Errorf("body %q, want %q", parsed.body, test.body)
+			}
+			if !reflect.DeepEqual(parsed.attachments, test.attachments) {
+				t.Errorf("attachments %q, want %q", parsed.attachments, test.attachments)
+			}
+		})
+	}
+}
+
+func TestParseMessageWithoutBody(t *testing.T) {
+	raw := testMessage(`multipart/mixed; boundary="b"`, "--b\n"+
+		"Content-Type: image/png; name=\"screenshot.png\"\n"+
+		"\n"+
+		"png\n"+
+		"--b--\n")
+	if _, err := parseMessage(raw); err == nil {
+		t.Error("message with only an image was given a body")
+	}
+}
+
+func TestExtractGmailMessageBody(t *testing.T) {
+	encode := func(text string) *gmail.MessagePartBody {
+		return &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(text))}
+	}
+	payload := &gmail.MessagePart{MimeType: "multipart/mixed", Parts: []*gmail.MessagePart{
+		{MimeType: "text/plain", Body: encode("Free the buffer.")},
+		{MimeType: "text/x-patch", Filename: "0001-mm.patch", Body: encode("diff --git a/mm/slab.c b/mm/slab.c"), Headers: []*gmail.MessagePartHeader{
+			{Name: "Content-Disposition", Value: `inline; filename="0001-mm.patch"`},
+		}},
+		{MimeType: "text/plain", Filename: "dmesg.txt", Body: &gmail.MessagePartBody{AttachmentId: "a1"}, Headers: []*gmail.MessagePartHeader{
+			{Name: "Content-Disposition", Value: `attachment; filename="dmesg.txt"`},
+		}},
+	}}
+	body, attachments, err := extractMessageBody(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "Free the buffer.\n\ndiff --git a/mm/slab.c b/mm/slab.c" {
+		t.Errorf("body %q", body)
+	}
+	if !reflect.DeepEqual(attachments, []string{"dmesg.txt"}) {
+		t.Errorf("attachments %q", attachments)
+	}
+}
@@ -0,0 +1,299 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	data2 "github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
+	"io"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PublicInboxConnector reads a local clone of a public-inbox v2 archive, where every commit of an epoch
+// repository adds a single message as the blob "m"
+type PublicInboxConnector struct {
+	config     config.PublicInboxConfig
+	collection string
+	quarantine quarantine
+	pending    publicInboxCheckpoint
+}
+
+// publicInboxMetadata identifies a message by the commit that added it to an epoch
+type publicInboxMetadata struct {
+	epoch     string
+	commit    string
+	timestamp int64
+}
+
+// publicInboxCheckpoint maps every epoch to the last ingested commit
+type publicInboxCheckpoint map[string]string
+
+func (m publicInboxMetadata) String() string {
+	return m.epoch + ":" + m.commit
+}
+
+func NewPublicInboxConnector(ctx context.Context, cfg config.PublicInboxConfig, collection string, quarantineStorage storage.Storage) (*PublicInboxConnector, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		logger.Error("git is required by the public-inbox source", slog.String("component", "Source"), slog.String("error", err.Error()))
+		return nil, err
+	}
+	connector := &PublicInboxConnector{config: cfg, collection: collection, quarantine: quarantine{storage: quarantineStorage, collection: collection}}
+	epochs, err := connector.epochs()
+	if err != nil {
+		logger.Error("could not list the epochs", slog.String("component", "Source"), slog.String("path", cfg.Path), slog.String("error", err.Error()))
+		return nil, err
+	}
+	if len(epochs) == 0 {
+		logger.Error("no epochs found in the inbox", slog.String("component", "Source"), slog.String("path", cfg.Path))
+		return nil, fmt.Errorf("no public-inbox v2 epochs found in %s", cfg.Path)
+	}
+	return connector, nil
+}
+
+// epochs lists the epoch repositories of the inbox in order
+func (s *PublicInboxConnector) epochs() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.config.Path, "git", "*.git"))
+	if err != nil {
+		return nil, err
+	}
+	epochNumber := func(path string) int {
+		number, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".git"))
+		return number
+	}
+	sort.Slice(paths, func(i, j int) bool { return epochNumber(paths[i]) < epochNumber(paths[j]) })
+
+	epochs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		epochs = append(epochs, filepath.Base(path))
+	}
+	return epochs, nil
+}
+
+func (s *PublicInboxConnector) git(ctx context.Context, epoch string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "git", append([]string{"--git-dir", filepath.Join(s.config.Path, "git", epoch)}, args...)...)
+}
+
+func (s *PublicInboxConnector) GetMetadata(ctx context.Context) ([]data2.Metadata, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	checkpoint := make(publicInboxCheckpoint)
+	if _, err := loadCheckpoint(s.config.Checkpoint, &checkpoint); err != nil {
+		logger.Error("could not load the checkpoint", slog.String("component", "Source"), slog.String("path", s.config.Checkpoint), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	epochs, err := s.epochs()
+	if err != nil {
+		logger.Error("could not list the epochs", slog.String("component", "Source"), slog.String("path", s.config.Path), slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	pending := make(publicInboxCheckpoint)
+	metadataList := make([]data2.Metadata, 0)
+	for _, epoch := range epochs {
+		logger.Info("fetching metadata", slog.String("component", "Source"), slog.String("epoch", epoch))
+		// a freshly created epoch has no commits yet
+		if err := s.git(ctx, epoch, "rev-parse", "--verify", "--quiet", "HEAD").Run(); err != nil {
+			continue
+		}
+
+		last, ok := checkpoint[epoch]
+		commits, err := s.listCommits(ctx, epoch, last)
+		if err != nil && ok {
+			// the checkpointed commit is gone when the epoch was rewritten, e.g. by public-inbox-purge
+			logger.Warn("checkpointed commit not found, reading the whole epoch", slog.String("component", "Source"), slog.String("epoch", epoch), slog.String("commit", last))
+			last = ""
+			commits, err = s.listCommits(ctx, epoch, "")
+		}
+		if err != nil {
+			logger.Error("could not list commits", slog.String("component", "Source"), slog.String("epoch", epoch), slog.String("error", err.Error()))
+			return nil, err
+		}
+
+		pending[epoch] = last
+		for _, commit := range commits {
+			metadataList = append(metadataList, commit)
+			pending[epoch] = commit.commit
+		}
+	}
+
+	s.pending = pending
+	logger.Info("fetched metadata", slog.String("component", "Source"), slog.Int("count", len(metadataList)))
+	return metadataList, nil
+}
+
+// listCommits lists the commits of an epoch after the given commit, oldest first
+func (s *PublicInboxConnector) listCommits(ctx context.Context, epoch string, after string) ([]publicInboxMetadata, error) {
+	revision := "HEAD"
+	if after != "" {
+		revision = after + "..HEAD"
+	}
+	output, err := s.git(ctx, epoch, "rev-list", "--reverse", "--timestamp", revision).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing commits: %w", err)
+	}
+
+	commits := make([]publicInboxMetadata, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		timestamp, _ := strconv.ParseInt(fields[0], 10, 64)
+		commits = append(commits, publicInboxMetadata{epoch: epoch, commit: fields[1], timestamp: timestamp})
+	}
+	return commits, nil
+}
+
+func (s *PublicInboxConnector) GetData(ctx context.Context, metadataList []data2.Metadata) ([]data2.Data, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	logger.Info("ingestion of data from the metadata", slog.String("component", "Source"))
+	epochs := make(map[string][]publicInboxMetadata)
+	order := make([]string, 0)
+	for _, metadataInterfaceComponent := range metadataList {
+		metadataComponent, ok := metadataInterfaceComponent.(publicInboxMetadata)
+		if !ok {
+			logger.Error("could not cast metadata", slog.String("component", "Source"))
+			return nil, fmt.Errorf("metadata interface component is not of type publicInboxMetadata")
+		}
+		if _, ok := epochs[metadataComponent.epoch]; !ok {
+			order = append(order, metadataComponent.epoch)
+		}
+		epochs[metadataComponent.epoch] = append(epochs[metadataComponent.epoch], metadataComponent)
+	}
+
+	dataList := make([]data2.Data, 0)
+	for _, epoch := range order {
+		err := s.readMessages(ctx, epoch, epochs[epoch], func(commit publicInboxMetadata, raw []byte) {
+			parsed, err := parseMessage(raw)
+			if err != nil {
+				s.quarantine.put(ctx, commit.String(), raw, err)
+				return
+			}
+			id := parsed.messageID
+			if id == "" {
+				id = commit.commit
+			}
+			date := parsed.date
+			if date.IsZero() {
+				date = time.Unix(commit.timestamp, 0)
+			}
+			list := s.config.List
+			if list == "" {
+				list = parsed.list
+			}
+			dataList = append(dataList, mailOrPatch(data2.MailData{
+				Source:      "public-inbox",
+				Data:        parsed.body,
+				Metadata:    data2.MailMetadata{Id: id},
+				Sender:      parsed.sender,
+				Subject:     parsed.subject,
+				Date:        date,
+				List:        list,
+				MessageID:   parsed.messageID,
+				InReplyTo:   parsed.inReplyTo,
+				References:  parsed.references,
+				Attachments: parsed.attachments,
+			}))
+		})
+		if err != nil {
+			logger.Error("could not read messages", slog.String("component", "Source"), slog.String("epoch", epoch), slog.String("error", err.Error()))
+			return nil, err
+		}
+	}
+	return dataList, nil
+}
+
+// readMessages streams the "m" blob of every commit through a single git cat-file process,
+// commits without one removed a message and are skipped
+func (s *PublicInboxConnector) readMessages(ctx context.Context, epoch string, commits []publicInboxMetadata, handle func(publicInboxMetadata, []byte)) error {
+	cmd := s.git(ctx, epoch, "cat-file", "--batch")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		defer stdin.Close()
+		for _, commit := range commits {
+			if _, err := fmt.Fprintf(stdin, "%s:m\n", commit.commit); err != nil {
+				return
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(stdout)
+	var readErr error
+	for _, commit := range commits {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			readErr = fmt.Errorf("error reading cat-file output: %w", err)
+			break
+		}
+		fields := strings.Fields(header)
+		if len(fields) == 2 && fields[1] == "missing" {
+			continue
+		}
+		if len(fields) != 3 {
+			readErr = fmt.Errorf("unexpected cat-file output %q", header)
+			break
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			readErr = fmt.Errorf("unexpected cat-file output %q", header)
+			break
+		}
+		raw := make([]byte, size+1) // the content is terminated by a newline
+		if _, err := io.ReadFull(reader, raw); err != nil {
+			readErr = fmt.Errorf("error reading cat-file output: %w", err)
+			break
+		}
+		handle(commit, bytes.TrimSuffix(raw, []byte("\n")))
+	}
+
+	if readErr != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return readErr
+	}
+	return cmd.Wait()
+}
+
+func (s *PublicInboxConnector) GetCollection(ctx context.Context) string {
+	logger := ctx.Value("logger").(*slog.Logger)
+	logger.Info("fetching collection", slog.String("collection", s.collection))
+	return s.collection
+}
+
+func (s *PublicInboxConnector) Checkpoint(ctx context.Context) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if err := saveCheckpoint(s.config.Checkpoint, s.pending); err != nil {
+		logger.Error("could not save the checkpoint", slog.String("component", "Source"), slog.String("path", s.config.Checkpoint), slog.String("error", err.Error()))
+		return err
+	}
+	logger.Info("saved the checkpoint", slog.String("component", "Source"), slog.String("path", s.config.Checkpoint))
+	return nil
+}
@@ -0,0 +1,34 @@
+package source
+
+import (
+	"context"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// quarantine keeps the messages a source could not make sense of, so that one bad message is set aside for
+// inspection instead of failing the whole batch it came with
+type quarantine struct {
+	storage    storage.Storage
+	collection string
+}
+
+// put stores the raw message under the collection, with the reason prepended as a header so the object is still a
+// valid message. Without a quarantine storage the message is only logged and dropped
+func (q quarantine) put(ctx context.Context, id string, raw []byte, reason error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	if q.storage == nil {
+		logger.Warn("dropping message, no quarantine storage is configured", slog.String("component", "Source"), slog.String("id", id), slog.String("reason", reason.Error()))
+		return
+	}
+	key := q.collection + "/" + url.PathEscape(id) + ".eml"
+	content := "X-Caelus-Quarantine-Reason: " + strings.ReplaceAll(reason.Error(), "\n", " ") + "\r\n" + string(raw)
+	if err := q.storage.Upload(ctx, key, content); err != nil {
+		logger.Error("could not quarantine message", slog.String("component", "Source"), slog.String("id", id), slog.String("error", err.Error()))
+		return
+	}
+	logger.Warn("quarantined message", slog.String("component", "Source"), slog.String("id", id), slog.String("key", key), slog.String("reason", reason.Error()))
+}
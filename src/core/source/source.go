@@ -6,17 +6,24 @@ import (
 	"fmt"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
 	"google.golang.org/api/gmail/v1"
 	"log/slog"
+	"mime"
+	"strings"
 )
 
 type Source interface {
 	GetMetadata(ctx context.Context) ([]data.Metadata, error)
 	GetData(ctx context.Context, metadataList []data.Metadata) ([]data.Data, error)
 	GetCollection(ctx context.Context) string
+	// Checkpoint persists the progress of the last GetMetadata call, it is called once all of its data is ingested
+	Checkpoint(ctx context.Context) error
 }
 
-func NewSource(ctx context.Context, sourceConfig config.Source) (Source, error) {
+// NewSource creates the source described by the config, messages it cannot ingest are put in the quarantine storage,
+// which may be nil
+func NewSource(ctx context.Context, sourceConfig config.Source, quarantineStorage storage.Storage) (Source, error) {
 	logger := ctx.Value("logger").(*slog.Logger)
 
 	rawSource, ok := sourceConfig.(config.RawSource)
@@ -32,40 +39,114 @@ func NewSource(ctx context.Context, sourceConfig config.Source) (Source, error)
 			return nil, fmt.Errorf("source config is not a gmail config")
 		}
 		logger.Info("creating a new gmail source", slog.String("component", "Source"), slog.String("type", rawSource.Type))
-		gmailSource, err := NewGmailConnector(ctx, gmailConfig, rawSource.Collection)
+		gmailSource, err := NewGmailConnector(ctx, gmailConfig, rawSource.Collection, quarantineStorage)
 		if err != nil {
 			return nil, err
 		}
 		return gmailSource, nil
+	case "imap":
+		imapConfig, ok := rawSource.Value.(config.ImapConfig)
+		if !ok {
+			logger.Error("could not cast imap config", slog.String("component", "Source"), slog.String("type", rawSource.Type))
+			return nil, fmt.Errorf("source config is not an imap config")
+		}
+		logger.Info("creating a new imap source", slog.String("component", "Source"), slog.String("type", rawSource.Type))
+		imapSource, err := NewImapConnector(ctx, imapConfig, rawSource.Collection, quarantineStorage)
+		if err != nil {
+			return nil, err
+		}
+		return imapSource, nil
+	case "file":
+		fileConfig, ok := rawSource.Value.(config.FileConfig)
+		if !ok {
+			logger.Error("could not cast file config", slog.String("component", "Source"), slog.String("type", rawSource.Type))
+			return nil, fmt.Errorf("source config is not a file config")
+		}
+		logger.Info("creating a new file source", slog.String("component", "Source"), slog.String("type", rawSource.Type))
+		fileSource, err := NewFileConnector(ctx, fileConfig, rawSource.Collection, quarantineStorage)
+		if err != nil {
+			return nil, err
+		}
+		return fileSource, nil
+	case "public-inbox":
+		publicInboxConfig, ok := rawSource.Value.(config.PublicInboxConfig)
+		if !ok {
+			logger.Error("could not cast public-inbox config", slog.String("component", "Source"), slog.String("type", rawSource.Type))
+			return nil, fmt.Errorf("source config is not a public-inbox config")
+		}
+		logger.Info("creating a new public-inbox source", slog.String("component", "Source"), slog.String("type", rawSource.Type))
+		publicInboxSource, err := NewPublicInboxConnector(ctx, publicInboxConfig, rawSource.Collection, quarantineStorage)
+		if err != nil {
+			return nil, err
+		}
+		return publicInboxSource, nil
 	default:
 		logger.Error("could not find source type", slog.String("component", "Source"), slog.String("type", rawSource.Type))
 		return nil, fmt.Errorf("source type %s is not supported", rawSource.Type)
 	}
 }
 
-func extractMessageBody(payload *gmail.MessagePart) (string, error) {
+// mailOrPatch turns a mail that carries a patch into patch data, leaving other mails as they are
+func mailOrPatch(mail data.MailData) data.Data {
+	if patch, ok := data.ParsePatch(mail); ok {
+		return patch
+	}
+	return mail
+}
+
+// extractMessageBody walks the parts of a Gmail message, preferring the text/plain alternative and converting the
+// HTML one to text when that is all there is. The Gmail API already removes the transfer encoding of every part,
+// so only the charset is left to convert. The file names of the attachments are returned alongside
+func extractMessageBody(payload *gmail.MessagePart) (string, []string, error) {
 	if payload == nil {
-		return "", fmt.Errorf("nil payload")
+		return "", nil, fmt.Errorf("nil payload")
+	}
+	var body messageBody
+	if err := walkGmailPart(payload, &body); err != nil {
+		return "", nil, err
+	}
+	text, err := body.text()
+	if err != nil {
+		return "", nil, err
+	}
+	return text, body.attachments, nil
+}
+
+func walkGmailPart(part *gmail.MessagePart, body *messageBody) error {
+	disposition := ""
+	params := map[string]string{"name": part.Filename}
+	for _, header := range part.Headers {
+		switch {
+		case strings.EqualFold(header.Name, "Content-Disposition"):
+			disposition = header.Value
+		case strings.EqualFold(header.Name, "Content-Type"):
+			if _, contentTypeParams, err := mime.ParseMediaType(header.Value); err == nil {
+				params["charset"] = contentTypeParams["charset"]
+			}
+		}
+	}
+	if name := attachmentName(disposition, part.MimeType, params); name != "" {
+		body.attachments = append(body.attachments, name)
+		return nil
 	}
-	if payload.MimeType == "text/plain" || payload.MimeType == "text/html" {
-		data := payload.Body.Data
-		if data == "" {
-			return "", fmt.Errorf("no data in part")
+	if isText(part.MimeType) {
+		if part.Body == nil || part.Body.Data == "" {
+			return nil
 		}
-		decodedData, err := base64.URLEncoding.DecodeString(data)
+		decodedData, err := base64.URLEncoding.DecodeString(part.Body.Data)
 		if err != nil {
-			decodedData, err = base64.StdEncoding.DecodeString(data)
+			decodedData, err = base64.StdEncoding.DecodeString(part.Body.Data)
 			if err != nil {
-				return "", fmt.Errorf("error decoding body: %w", err)
+				return fmt.Errorf("error decoding body: %w", err)
 			}
 		}
-		return string(decodedData), nil
+		body.addText(part.MimeType, decodeCharset(params["charset"], decodedData))
+		return nil
 	}
-	for _, part := range payload.Parts {
-		body, err := extractMessageBody(part)
-		if err == nil && body != "" {
-			return body, nil
+	for _, child := range part.Parts {
+		if err := walkGmailPart(child, body); err != nil {
+			return err
 		}
 	}
-	return "", fmt.Errorf("no body found")
+	return nil
 }
@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/buffer"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/chunker"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/cleaner"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/engine"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/sink"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/source"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 )
 
 type IngestionManager interface {
@@ -17,12 +21,13 @@ type IngestionManager interface {
 }
 
 type ingestionManager struct {
-	sources       []source.Source
-	engine        engine.Engine
-	buffer        buffer.Buffer
-	sinks         []sink.Sink
-	routines      int
-	embeddingSize int
+	sources  []source.Source
+	cleaner  cleaner.Cleaner
+	chunker  chunker.Chunker
+	engine   engine.Engine
+	buffer   buffer.Buffer
+	sinks    []sink.Sink
+	routines int
 }
 
 func NewIngestionManager(ctx context.Context, config *config.Config) (IngestionManager, error) {
@@ -35,11 +40,35 @@ func NewIngestionManager(ctx context.Context, config *config.Config) (IngestionM
 		return nil, err
 	}
 
+	logger.Info("creating a new cleaner", slog.String("component", "ingestionManager"))
+	newCleaner, err := cleaner.NewCleaner(ctx, config.Cleaner)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("creating a new chunker", slog.String("component", "ingestionManager"))
+	newChunker, err := chunker.NewChunker(ctx, config.Chunker)
+	if err != nil {
+		return nil, err
+	}
+
+	// messages the sources cannot ingest are set aside in the quarantine storage, when one is configured
+	var quarantineStorage storage.Storage
+	for _, storageConfig := range config.Storage {
+		if storageConfig.Kind == "quarantine" {
+			logger.Info("creating a new storage", slog.String("component", "ingestionManager"), slog.String("storageType", storageConfig.Type))
+			quarantineStorage, err = storage.NewStorage(ctx, storageConfig)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	var sources []source.Source
 	var sinks []sink.Sink
 	for _, sourceConfig := range config.Sources {
 		logger.Info("creating a new source", slog.String("component", "ingestionManager"), slog.String("ingestionSourceType", sourceConfig.Type))
-		newSource, err := source.NewSource(ctx, sourceConfig)
+		newSource, err := source.NewSource(ctx, sourceConfig, quarantineStorage)
 		if err != nil {
 			return nil, err
 		}
@@ -60,19 +89,22 @@ func NewIngestionManager(ctx context.Context, config *config.Config) (IngestionM
 	}
 
 	return ingestionManager{
-		sources:       sources,
-		engine:        newEngine,
-		buffer:        newBuffer,
-		sinks:         sinks,
-		routines:      config.Application.IngestionRoutines,
-		embeddingSize: config.Application.EmbeddingSize,
+		sources:  sources,
+		cleaner:  newCleaner,
+		chunker:  newChunker,
+		engine:   newEngine,
+		buffer:   newBuffer,
+		sinks:    sinks,
+		routines: config.Application.IngestionRoutines,
 	}, nil
 }
 
 func (ingestionManager ingestionManager) Run(ctx context.Context) error {
-	var wg sync.WaitGroup
+	logger := ctx.Value("logger").(*slog.Logger)
 
-	for _, ingestionSource := range ingestionManager.sources {
+	var wg sync.WaitGroup
+	failed := make([]atomic.Bool, len(ingestionManager.sources))
+	for sourceIndex, ingestionSource := range ingestionManager.sources {
 		// getting the metadata
 		metadataList, err := ingestionSource.GetMetadata(ctx)
 		if err != nil {
@@ -97,33 +129,53 @@ func (ingestionManager ingestionManager) Run(ctx context.Context) error {
 				go func(batch []data.Metadata) {
 					defer wg.Done()
 
-					ingest(ctx, ingestionSource, ingestionManager.buffer, ingestionSink, ingestionManager.embeddingSize, batch)
+					err := ingest(ctx, ingestionSource, ingestionManager.cleaner, ingestionManager.chunker, ingestionManager.buffer, ingestionSink, batch)
+					if err != nil {
+						failed[sourceIndex].Store(true)
+					}
 				}(batch)
 			}
 		}
 	}
 	wg.Wait()
+
+	// only move the checkpoint of sources that were completely ingested, the rest are retried on the next run
+	for sourceIndex, ingestionSource := range ingestionManager.sources {
+		if failed[sourceIndex].Load() {
+			logger.Warn("skipping checkpoint of partially ingested source", slog.String("component", "ingestionManager"), slog.String("collection", ingestionSource.GetCollection(ctx)))
+			continue
+		}
+		if err := ingestionSource.Checkpoint(ctx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func ingest(ctx context.Context, source source.Source, buffer buffer.Buffer, sink sink.Sink, embeddingSize int, metadataList []data.Metadata) {
+func ingest(ctx context.Context, source source.Source, cleaner cleaner.Cleaner, chunker chunker.Chunker, buffer buffer.Buffer, sink sink.Sink, metadataList []data.Metadata) error {
 	// get an embedding for each of the messages
 	ingestedData, err := source.GetData(ctx, metadataList)
 	if err != nil {
-		return
+		return err
 	}
 
+	// strip the quotes, signatures and footers that would otherwise dominate the embeddings
+	ingestedData = cleaner.Clean(ctx, ingestedData)
+
+	// split the documents that are too long to be embedded as a whole
+	ingestedData = chunker.Chunk(ctx, ingestedData)
+
 	// push the embedding to the vector DB
-	metadataList, err = sink.Upsert(ctx, ingestedData, embeddingSize)
+	metadataList, err = sink.Upsert(ctx, ingestedData)
 	if err != nil {
-		return
+		return err
 	}
 
 	// push metadata in a bulk insert to the buffer
 	err = buffer.EnqueueBatch(ctx, metadataList)
 	if err != nil {
-		return
+		return err
 	}
 
-	return
+	return nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// migrationCheckpoint is the progress of the migration of one sink
+type migrationCheckpoint struct {
+	// Alias is the alias swapped to the target, the collection of the sink or a new alias when that is a collection
+	Alias string `json:"alias"`
+	// Source is the collection the points are read from, Target the collection they are written to
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// Offset is the id of the next point to migrate, empty before the first page and once every point is copied
+	Offset   string `json:"offset"`
+	Migrated uint64 `json:"migrated"`
+	Copied   bool   `json:"copied"`
+}
+
+// migrationCheckpoints are keyed by the collection the sinks are configured with
+type migrationCheckpoints map[string]migrationCheckpoint
+
+func loadCheckpoints(path string) (migrationCheckpoints, error) {
+	checkpoints := make(migrationCheckpoints)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return checkpoints, nil
+		}
+		return nil, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(content, &checkpoints); err != nil {
+		return nil, fmt.Errorf("error decoding checkpoint: %w", err)
+	}
+	return checkpoints, nil
+}
+
+// save writes the checkpoints going through a temporary file, so that an interrupted migration never leaves a
+// partial checkpoint behind
+func (c migrationCheckpoints) save(path string) error {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating checkpoint directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error replacing checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+package main
+
+const (
+	// pageSize is the number of points read, embedded and written at a time, progress is checkpointed after every page
+	pageSize = 64
+)
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	// create a new context, cancelled on interruption so that the migration stops at a checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// create a new logger
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ctx = context.WithValue(ctx, "logger", logger)
+
+	// getting the newConfig
+	configPath := flag.String("newConfig", "config.yaml", "Path to configuration file")
+	checkpointPath := flag.String("checkpoint", "checkpoints/migration.json", "Path to the file the migration progress is persisted to")
+	target := flag.String("target", "", "Collection to re-embed into, defaults to the alias suffixed with the start time")
+	alias := flag.String("alias", "", "Alias to create when the sink collection is not an alias yet, defaults to the collection suffixed with _alias")
+	replaceCollection := flag.Bool("replaceCollection", false, "Drop the sink collection once it is copied and create the alias under its name instead, the name does not resolve in between")
+	flag.Parse()
+	newConfig, err := config.NewConfig(*configPath)
+	if err != nil {
+		logger.Error("Error reading configuration file", "error", err)
+		os.Exit(1)
+	}
+
+	// getting the migration manager
+	manager, err := NewMigrationManager(ctx, newConfig, *checkpointPath, *target, *alias, *replaceCollection)
+	if err != nil {
+		logger.Error("Error creating migration manager", "error", err)
+		os.Exit(1)
+	}
+
+	err = manager.Run(ctx)
+	if err != nil {
+		logger.Error("Error running migration manager", "error", err)
+		os.Exit(1)
+	}
+}
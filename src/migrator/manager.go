@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/config"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/engine"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/sink"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+type MigrationManager interface {
+	Run(ctx context.Context) error
+}
+
+// migrationManager re-embeds the points behind the alias of every qdrant sink with the configured engine into a new
+// collection, and then points the alias to it. A sink configured with a plain collection gets a new alias, which the
+// config has to be switched to, unless replaceCollection drops the collection to give the alias its name. The
+// ingestor and the processor should be stopped while it runs, as points written or consumed during the migration may
+// not be carried over
+type migrationManager struct {
+	engine            engine.Engine
+	sinks             []config.QdrantConfig
+	checkpointPath    string
+	target            string
+	alias             string
+	replaceCollection bool
+}
+
+func NewMigrationManager(ctx context.Context, newConfig *config.Config, checkpointPath string, target string, alias string, replaceCollection bool) (MigrationManager, error) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	logger.Info("creating a new migration manager", slog.String("component", "migrationManager"))
+	logger.Info("creating a new engine", slog.String("component", "migrationManager"))
+	newEngine, err := engine.NewEngine(ctx, newConfig.Engine)
+	if err != nil {
+		return nil, err
+	}
+
+	sinks := make([]config.QdrantConfig, 0, len(newConfig.Sinks))
+	for _, sinkConfig := range newConfig.Sinks {
+		qdrantConfig, ok := sinkConfig.Value.(config.QdrantConfig)
+		if !ok {
+			logger.Warn("skipping sink that cannot be migrated", slog.String("component", "migrationManager"), slog.String("sinkType", sinkConfig.Type))
+			continue
+		}
+		sinks = append(sinks, qdrantConfig)
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("no qdrant sink is configured")
+	}
+	if target != "" && len(sinks) > 1 {
+		return nil, fmt.Errorf("a target collection can only be given when a single sink is configured")
+	}
+	if alias != "" && len(sinks) > 1 {
+		return nil, fmt.Errorf("an alias can only be given when a single sink is configured")
+	}
+
+	return migrationManager{
+		engine:            newEngine,
+		sinks:             sinks,
+		checkpointPath:    checkpointPath,
+		target:            target,
+		alias:             alias,
+		replaceCollection: replaceCollection,
+	}, nil
+}
+
+func (migrationManager migrationManager) Run(ctx context.Context) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	checkpoints, err := loadCheckpoints(migrationManager.checkpointPath)
+	if err != nil {
+		logger.Error("could not load the checkpoint", slog.String("component", "migrationManager"), slog.String("path", migrationManager.checkpointPath), slog.Any("error", err))
+		return err
+	}
+	for _, qdrantConfig := range migrationManager.sinks {
+		if err := migrationManager.migrate(ctx, qdrantConfig, checkpoints); err != nil {
+			return err
+		}
+	}
+
+	// every alias has been swapped, so a later migration starts from scratch
+	if err := os.Remove(migrationManager.checkpointPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// migrate copies the points behind the collection of one sink, resuming from the checkpoint of an interrupted run
+func (migrationManager migrationManager) migrate(ctx context.Context, qdrantConfig config.QdrantConfig, checkpoints migrationCheckpoints) error {
+	logger := ctx.Value("logger").(*slog.Logger)
+	name := qdrantConfig.Collection
+
+	// the connector of the alias only reads, so it is created without an engine and leaves the collection untouched
+	aliasConnector, err := sink.NewQdrantConnector(ctx, qdrantConfig, nil)
+	if err != nil {
+		return err
+	}
+
+	checkpoint, resumed := checkpoints[name]
+	if !resumed {
+		source, isAlias, err := aliasConnector.ResolveAlias(ctx)
+		if err != nil {
+			return err
+		}
+		alias := name
+		if !isAlias && !migrationManager.replaceCollection {
+			// the collection keeps serving the readers, the migrated one is reached through an alias of its own
+			alias = migrationManager.alias
+			if alias == "" {
+				alias = name + "_alias"
+			}
+		}
+		target := migrationManager.target
+		if target == "" {
+			target = name + "_" + strconv.FormatInt(time.Now().Unix(), 10)
+		}
+		if target == source {
+			return fmt.Errorf("target collection %s is the collection being migrated", target)
+		}
+		checkpoint = migrationCheckpoint{Alias: alias, Source: source, Target: target}
+		checkpoints[name] = checkpoint
+		if err := checkpoints.save(migrationManager.checkpointPath); err != nil {
+			return err
+		}
+	}
+	// checkpoints written before the alias was recorded always swapped the collection of the sink
+	if checkpoint.Alias == "" {
+		checkpoint.Alias = name
+	}
+	alias := checkpoint.Alias
+	logger.Info("migrating collection", slog.String("component", "migrationManager"), slog.String("collection", name), slog.String("alias", alias), slog.String("source", checkpoint.Source), slog.String("target", checkpoint.Target), slog.Bool("resumed", resumed), slog.Uint64("migrated", checkpoint.Migrated))
+
+	if !checkpoint.Copied {
+		// creating the connector of the target creates the collection for the dimension of the new engine
+		targetConfig := qdrantConfig
+		targetConfig.Collection = checkpoint.Target
+		targetConfig.OnDimensionMismatch = "error"
+		targetConnector, err := sink.NewQdrantConnector(ctx, targetConfig, migrationManager.engine)
+		if err != nil {
+			return err
+		}
+
+		total, err := aliasConnector.Count(ctx, checkpoint.Source)
+		if err != nil {
+			logger.Error("could not count the points", slog.String("component", "migrationManager"), slog.String("collection", checkpoint.Source), slog.Any("error", err))
+			return err
+		}
+		started := time.Now()
+		startedAt := checkpoint.Migrated
+		for {
+			points, next, err := aliasConnector.ScrollPage(ctx, checkpoint.Source, checkpoint.Offset, pageSize)
+			if err != nil {
+				return err
+			}
+			if err := targetConnector.Reembed(ctx, points); err != nil {
+				logger.Error("could not re-embed the points", slog.String("component", "migrationManager"), slog.String("target", checkpoint.Target), slog.Any("error", err))
+				return err
+			}
+
+			checkpoint.Migrated += uint64(len(points))
+			checkpoint.Offset = next
+			checkpoint.Copied = next == ""
+			checkpoints[name] = checkpoint
+			if err := checkpoints.save(migrationManager.checkpointPath); err != nil {
+				return err
+			}
+			logProgress(ctx, alias, checkpoint.Migrated, total, checkpoint.Migrated-startedAt, time.Since(started))
+
+			if checkpoint.Copied {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				logger.Warn("migration interrupted, it resumes from the checkpoint on the next run", slog.String("component", "migrationManager"), slog.String("alias", alias))
+				return err
+			}
+		}
+	}
+
+	dropped, err := aliasConnector.SwapAlias(ctx, alias, checkpoint.Target, migrationManager.replaceCollection)
+	if err != nil {
+		return err
+	}
+	delete(checkpoints, name)
+	if err := checkpoints.save(migrationManager.checkpointPath); err != nil {
+		return err
+	}
+	attrs := []any{slog.String("component", "migrationManager"), slog.String("alias", alias), slog.String("previous", checkpoint.Source), slog.String("collection", checkpoint.Target)}
+	switch {
+	case alias != name:
+		logger.Warn("migrated collection under a new alias, set the collection of the sink to the alias in the config to use it. The previous collection is untouched and can be dropped once the new one is verified", attrs...)
+	case dropped:
+		logger.Info("migrated collection, the previous collection was dropped to give the alias its name", attrs...)
+	default:
+		logger.Info("migrated collection, the previous collection is kept and can be dropped once the new one is verified", attrs...)
+	}
+	return nil
+}
+
+// logProgress reports how many points are migrated, and estimates the remaining time from the rate of this run
+func logProgress(ctx context.Context, alias string, migrated uint64, total uint64, sinceStart uint64, elapsed time.Duration) {
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	attrs := []any{slog.String("component", "migrationManager"), slog.String("alias", alias), slog.Uint64("migrated", migrated), slog.Uint64("total", total)}
+	if total > 0 {
+		attrs = append(attrs, slog.Float64("percent", float64(min(migrated, total))*100/float64(total)))
+	}
+	if sinceStart > 0 && elapsed > 0 {
+		rate := float64(sinceStart) / elapsed.Seconds()
+		attrs = append(attrs, slog.Float64("pointsPerSecond", rate))
+		if total > migrated {
+			attrs = append(attrs, slog.Duration("remaining", time.Duration(float64(total-migrated)/rate*float64(time.Second))))
+		}
+	}
+	logger.Info("migration progress", attrs...)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/ChinmayaSharma-hue/caelus/src/core/sink"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
 	"log/slog"
+	"time"
 )
 
 type ProcessorManager interface {
@@ -20,6 +21,7 @@ type processorManager struct {
 	preprocessedBuffer buffer.Buffer
 	processedBuffer    buffer.Buffer
 	maxPromptTokens    int
+	maxContextAge      time.Duration
 }
 
 func NewProcessorManager(ctx context.Context, appConfig *config.Config) (ProcessorManager, error) {
@@ -76,12 +78,24 @@ func NewProcessorManager(ctx context.Context, appConfig *config.Config) (Process
 		return nil, err
 	}
 
+	var maxContextAge time.Duration
+	if appConfig.Application.MaxContextAge != "" {
+		maxContextAge, err = time.ParseDuration(appConfig.Application.MaxContextAge)
+		if err != nil {
+			logger.Error("invalid max context age",
+				slog.String("component", "processorManager"),
+				slog.String("maxContextAge", appConfig.Application.MaxContextAge))
+			return nil, err
+		}
+	}
+
 	return processorManager{
 		sinks:              sinks,
 		storages:           storages,
 		preprocessedBuffer: preprocessedBuffer,
 		processedBuffer:    processedBuffer,
 		maxPromptTokens:    appConfig.Application.MaxPromptTokens,
+		maxContextAge:      maxContextAge,
 	}, nil
 }
 
@@ -100,6 +114,7 @@ func (p processorManager) Run(ctx context.Context) {
 					storage:            promptStorage,
 					cancel:             wcancel,
 					maxPromptTokens:    p.maxPromptTokens,
+					maxContextAge:      p.maxContextAge,
 				}
 				go workers[i].Start()
 			}
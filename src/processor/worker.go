@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/buffer"
+	"github.com/ChinmayaSharma-hue/caelus/src/core/data"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/sink"
 	"github.com/ChinmayaSharma-hue/caelus/src/core/storage"
 	"github.com/google/uuid"
@@ -10,7 +12,9 @@ import (
 	"log"
 	"log/slog"
 	"os"
-	"strconv"
+	"sort"
+	"strings"
+	"time"
 )
 
 // worker listens to the preprocessedBuffer, fetches vectors from the sink and then constructs the prompts and stores it in storage
@@ -22,6 +26,8 @@ type worker struct {
 	ctx                context.Context
 	cancel             context.CancelFunc
 	maxPromptTokens    int
+	// maxContextAge drops older context when it is not zero
+	maxContextAge time.Duration
 }
 
 type promptID struct {
@@ -50,9 +56,14 @@ func (w *worker) Start() {
 				continue
 			}
 
-			// process the message
+			// process the message, a message for a document that is already consumed is a copy enqueued by a later
+			// ingestion of it and is acknowledged rather than redelivered
 			err = w.processMessage(message)
-			if err != nil {
+			if errors.Is(err, sink.ErrReferenceNotFound) {
+				logger.Info("dropping message for consumed data",
+					slog.String("component", "processor"),
+					slog.String("id", message.GetMessageData()))
+			} else if err != nil {
 				continue
 			}
 
@@ -66,6 +77,55 @@ func (w *worker) Start() {
 	}
 }
 
+// formatThread renders a thread as a conversation, every mail preceded by its headers
+func formatThread(thread []data.Data) string {
+	var builder strings.Builder
+	for _, d := range thread {
+		if mail, ok := data.AsMail(d); ok {
+			builder.WriteString("From: " + mail.Sender + "\n")
+			builder.WriteString("Subject: " + mail.Subject + "\n\n")
+		}
+		builder.WriteString(d.String())
+		builder.WriteString("\n\n")
+	}
+	return builder.String()
+}
+
+// contextQuery asks the sink for the unconsumed context closest to the message. Context older than the max context
+// age is excluded by the sink, so that it does not take the place of newer context among the results. Data without a
+// date is stored with the zero time, which the excluded range leaves out so that it is kept
+func (w *worker) contextQuery(id string) sink.Query {
+	filter := sink.Filter{Must: []sink.Condition{sink.Equals("consumed", false)}}
+	if w.maxContextAge > 0 {
+		undated := float64(time.Time{}.Unix())
+		cutoff := float64(time.Now().Add(-w.maxContextAge).Unix())
+		filter.MustNot = append(filter.MustNot, sink.InRange("date", sink.Range{Gt: &undated, Lt: &cutoff}))
+	}
+	return sink.Query{
+		Collection:  w.sink.GetCollection(w.ctx),
+		ReferenceID: id,
+		Filter:      filter,
+		Limit:       maxVectorFetch,
+	}
+}
+
+// orderContext orders the context newest first, so that the most recent context is what fills the prompt. Data
+// without a date goes last
+func (w *worker) orderContext(dataMap map[string]data.Data) []string {
+	ids := make([]string, 0, len(dataMap))
+	for u := range dataMap {
+		ids = append(ids, u)
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		di, dj := dataMap[ids[i]].GetDate(), dataMap[ids[j]].GetDate()
+		if di.Equal(dj) {
+			return ids[i] < ids[j]
+		}
+		return di.After(dj)
+	})
+	return ids
+}
+
 func (w *worker) processMessage(message buffer.Message) error {
 	logger := w.ctx.Value("logger").(*slog.Logger)
 
@@ -73,12 +133,7 @@ func (w *worker) processMessage(message buffer.Message) error {
 	id := message.GetMessageData()
 
 	// fetch all the vectors from the database that are closest to this message
-	filters := map[string]string{
-		"collection": w.sink.GetCollection(w.ctx),
-		"id":         id,
-		"count":      strconv.Itoa(maxVectorFetch),
-	}
-	dataMap, err := w.sink.Fetch(w.ctx, filters)
+	dataMap, err := w.sink.Fetch(w.ctx, w.contextQuery(id))
 	if err != nil {
 		return err
 	}
@@ -94,25 +149,53 @@ func (w *worker) processMessage(message buffer.Message) error {
 	}
 	consumedUUIDS := make([]string, 0)
 	prompt := string(promptBytes)
-	for u, data := range dataMap {
-		// constructing a temporary prompt by using the next data
-		temporaryPrompt := prompt
-		temporaryPrompt += data.String()
-
-		// count the tokens for temporary prompt
-		enc, err := tiktoken.EncodingForModel("gpt-4o-preview")
-		if err != nil {
-			log.Fatal(err)
+	includedThreads := make(map[string]bool)
+	for _, u := range w.orderContext(dataMap) {
+		d := dataMap[u]
+		// prefer the whole conversation over the single mail, falling back to the mail if the thread does not fit
+		candidates := []string{d.String()}
+		var threadIDs []string
+		if mail, ok := data.AsMail(d); ok {
+			root := mail.Thread().Root
+			if includedThreads[root] {
+				consumedUUIDS = append(consumedUUIDS, u)
+				continue
+			}
+			if root != "" {
+				thread, ids, err := w.sink.FetchThread(w.ctx, root)
+				if err == nil && len(thread) > 1 {
+					candidates = append([]string{formatThread(thread)}, candidates...)
+					threadIDs = ids
+				}
+			}
 		}
-		tokens := enc.Encode(temporaryPrompt, nil, nil)
-		tokenCount := len(tokens)
 
-		// decide whether to keep the prompt or not
-		if tokenCount > w.maxPromptTokens {
-			continue
+		for i, candidate := range candidates {
+			// constructing a temporary prompt by using the next data
+			temporaryPrompt := prompt
+			temporaryPrompt += candidate
+
+			// count the tokens for temporary prompt
+			enc, err := tiktoken.EncodingForModel("gpt-4o-preview")
+			if err != nil {
+				log.Fatal(err)
+			}
+			tokens := enc.Encode(temporaryPrompt, nil, nil)
+			tokenCount := len(tokens)
+
+			// decide whether to keep the prompt or not
+			if tokenCount > w.maxPromptTokens {
+				continue
+			}
+			prompt = temporaryPrompt
+			consumedUUIDS = append(consumedUUIDS, u)
+			if mail, ok := data.AsMail(d); ok && i == 0 && len(candidates) > 1 {
+				// every mail of the thread is in the prompt now, so none of them is new context for a later prompt
+				consumedUUIDS = append(consumedUUIDS, threadIDs...)
+				includedThreads[mail.Thread().Root] = true
+			}
+			break
 		}
-		prompt = temporaryPrompt
-		consumedUUIDS = append(consumedUUIDS, u)
 	}
 
 	// mark all the vectors that will be used as consumed